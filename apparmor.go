@@ -0,0 +1,290 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/juju/errors"
+)
+
+// AppArmorProfile describes a generated per-container AppArmor profile.
+//
+// The generated profile builds on the same base rules as
+// lxc-container-default, and denies access to host device classes which are
+// not declared as mounts. Once the application is installed, it also denies
+// writes to system directories other than Writable paths.
+type AppArmorProfile struct {
+	// Writable lists additional container paths the application may write.
+	Writable []string
+
+	// Complain logs violations rather than denying them, which is useful for
+	// discovering the rules an application needs. Accesses the profile would
+	// deny are audited, so that they are logged even though the base rules
+	// permit them.
+	Complain bool
+}
+
+func AppArmor(profile *AppArmorProfile) Option {
+	return func(c *Container) error {
+		if profile != nil {
+			for _, writable := range profile.Writable {
+				if !path.IsAbs(writable) {
+					return errors.Errorf("apparmor writable path %q must be absolute", writable)
+				}
+			}
+		}
+		c.apparmor = profile
+		return nil
+	}
+}
+
+// AppArmorProfileName returns the name of the generated AppArmor profile for
+// the container.
+func (c *Container) AppArmorProfileName() string {
+	return "lxcify-" + c.Name()
+}
+
+// appArmorInstallProfileName returns the name of the generated AppArmor
+// profile the container runs under until the application is installed.
+func (c *Container) appArmorInstallProfileName() string {
+	return c.AppArmorProfileName() + "-install"
+}
+
+// readOnlyRoots are system directories the application may not write to once
+// installed, unless a writable path is declared within them.
+var readOnlyRoots = []string{
+	"/bin", "/boot", "/etc", "/lib", "/lib32", "/lib64", "/opt", "/sbin", "/usr",
+}
+
+type deviceClass struct {
+	name  string
+	paths []string
+}
+
+// deviceClasses are host devices which are denied unless mounted into the
+// container.
+var deviceClasses = []deviceClass{
+	{"camera", []string{"/dev/video*"}},
+	{"sound", []string{"/dev/snd/", "/dev/snd/**"}},
+	{"dri", []string{"/dev/dri/", "/dev/dri/**"}},
+	{"nvidia", []string{"/dev/nvidia*", "/dev/nvidia*/**"}},
+	{"input", []string{"/dev/input/", "/dev/input/**"}},
+	{"usb", []string{"/dev/bus/usb/", "/dev/bus/usb/**"}},
+}
+
+const appArmorTemplate = `# Generated by lxcify for container {{.Name}}.
+#include <tunables/global>
+{{range .Profiles}}
+# {{.Comment}}
+profile {{.Profile}} flags=({{$.Flags}}) {
+  #include <abstractions/lxc/container-base>
+{{range $.Mounts}}
+  # mount: {{.}}{{end}}
+{{range $.Devices}}
+  # {{.name}} devices are not mounted
+{{range .paths}}  {{$.Deny}} {{.}} rwklx,
+{{end}}{{end}}
+{{range .ReadOnly}}  {{$.Deny}} {{.}}/** wl,
+{{end}}{{range $.Writable}}
+  # writable: {{.}}{{end}}
+{{range $.Rules}}  {{$.Deny}} {{.}}
+{{end}}}
+{{end}}`
+
+// appArmorDenials returns additional rules to deny, derived from other
+// container settings.
+func (c *Container) appArmorDenials() []string {
+	var rules []string
+	if c.network.Mode == NetworkNone {
+		rules = append(rules, "network inet,", "network inet6,")
+	}
+	return rules
+}
+
+// appArmorProfile renders the container's AppArmor profiles: the application
+// profile, and a profile for installation which leaves system directories
+// writable.
+func (c *Container) appArmorProfile(containerMounts []Mount) (string, error) {
+	var mounts []string
+	mounted := make(map[string]bool)
+	for _, mount := range containerMounts {
+		containerPath := "/" + mount.Container
+		mounts = append(mounts, fmt.Sprintf("%s -> %s", mount.Host, containerPath))
+		for _, class := range deviceClasses {
+			prefix := strings.TrimRight(strings.TrimSuffix(class.paths[0], "*"), "/")
+			if strings.HasPrefix(containerPath, prefix) {
+				mounted[class.name] = true
+			}
+		}
+	}
+	var devices []map[string]interface{}
+	for _, class := range deviceClasses {
+		if !mounted[class.name] {
+			devices = append(devices, map[string]interface{}{"name": class.name, "paths": class.paths})
+		}
+	}
+
+	var readOnly []string
+	for _, root := range readOnlyRoots {
+		writable := false
+		for _, w := range c.apparmor.Writable {
+			if w == root || strings.HasPrefix(w, root+"/") {
+				writable = true
+			}
+		}
+		if !writable {
+			readOnly = append(readOnly, root)
+		}
+	}
+
+	// Complain mode doesn't log accesses denied by explicit deny rules, so
+	// they are audited instead.
+	flags, deny := "attach_disconnected,mediate_deleted", "deny"
+	if c.apparmor.Complain {
+		flags, deny = flags+",complain", "audit"
+	}
+	profiles := []map[string]interface{}{{
+		"Comment":  "Confines the installed application.",
+		"Profile":  c.AppArmorProfileName(),
+		"ReadOnly": readOnly,
+	}, {
+		"Comment": "Confines the container while the application is installed.",
+		"Profile": c.appArmorInstallProfileName(),
+	}}
+
+	t, err := template.New("apparmor").Parse(appArmorTemplate)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, map[string]interface{}{
+		"Name":     c.Name(),
+		"Profiles": profiles,
+		"Flags":    flags,
+		"Deny":     deny,
+		"Mounts":   mounts,
+		"Devices":  devices,
+		"Writable": c.apparmor.Writable,
+		"Rules":    c.appArmorDenials(),
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return buf.String(), nil
+}
+
+// setupAppArmor writes the generated profiles to the container directory,
+// loads them into the kernel and confines the container with the install
+// profile. The container is switched to the application profile once the
+// application is installed, so that the install script may write system
+// directories.
+func (c *Container) setupAppArmor(mounts []Mount) error {
+	profile, err := c.appArmorProfile(mounts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	profilePath := path.Join(c.ConfigPath(), c.Name(), "apparmor.profile")
+	f, err := os.OpenFile(profilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprint(f, profile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	cmd := exec.Command("sudo", "apparmor_parser", "-r", "-W", profilePath)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return errors.Annotate(err, "cannot load apparmor profile")
+	}
+	return errors.Trace(c.SetConfigItem("lxc.aa_profile", c.appArmorInstallProfileName()))
+}
+
+// AppArmorEvent is an AppArmor audit message for a container's profile.
+type AppArmorEvent struct {
+	Status    string
+	Operation string
+	Name      string
+	Mask      string
+}
+
+var auditFieldPattern = regexp.MustCompile(`([a-z_]+)=("[^"]*"|\S+)`)
+
+// AppArmorEvents parses kernel log messages, returning the AppArmor denials,
+// complaints and audited accesses for the named profile.
+func AppArmorEvents(profile string, kernelLog io.Reader) ([]AppArmorEvent, error) {
+	var events []AppArmorEvent
+	scanner := bufio.NewScanner(kernelLog)
+	for scanner.Scan() {
+		fields := make(map[string]string)
+		for _, match := range auditFieldPattern.FindAllStringSubmatch(scanner.Text(), -1) {
+			fields[match[1]] = strings.Trim(match[2], `"`)
+		}
+		if fields["profile"] != profile {
+			continue
+		}
+		switch fields["apparmor"] {
+		case "DENIED", "ALLOWED", "AUDIT":
+		default:
+			continue
+		}
+		events = append(events, AppArmorEvent{
+			Status:    fields["apparmor"],
+			Operation: fields["operation"],
+			Name:      fields["name"],
+			Mask:      fields["requested_mask"],
+		})
+	}
+	return events, errors.Trace(scanner.Err())
+}
+
+// SuggestTemplateRules returns template snippets which would permit the
+// given AppArmor events.
+func SuggestTemplateRules(events []AppArmorEvent) []string {
+	suggestions := make(map[string]bool)
+	for _, event := range events {
+		switch {
+		case event.Name == "":
+			continue
+		case strings.HasPrefix(event.Name, "/dev/"):
+			suggestions[fmt.Sprintf("mounts:\n  - passthru: %s", event.Name)] = true
+		case strings.ContainsAny(event.Mask, "wacdl"):
+			suggestions[fmt.Sprintf("apparmor:\n  writable:\n    - %s", path.Dir(event.Name))] = true
+		}
+	}
+	var result []string
+	for suggestion := range suggestions {
+		result = append(result, suggestion)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,94 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+type AppArmorSuite struct{}
+
+var _ = gc.Suite(&AppArmorSuite{})
+
+// profileSection returns the body of the named profile in rendered profiles.
+func profileSection(c *gc.C, profiles, name string) string {
+	start := strings.Index(profiles, "profile "+name+" ")
+	c.Assert(start, gc.Not(gc.Equals), -1)
+	end := strings.Index(profiles[start:], "\n}\n")
+	c.Assert(end, gc.Not(gc.Equals), -1)
+	return profiles[start : start+end]
+}
+
+func (*AppArmorSuite) TestProfile(c *gc.C) {
+	container := newTestContainer(c,
+		AppArmor(&AppArmorProfile{Writable: []string{"/opt/app"}}),
+		Network(NetworkPolicy{Mode: NetworkNone}))
+	profiles, err := container.appArmorProfile([]Mount{MountDRI, MountX11})
+	c.Assert(err, gc.IsNil)
+
+	app := profileSection(c, profiles, "lxcify-test")
+	c.Check(app, gc.Matches, `profile lxcify-test flags=\(attach_disconnected,mediate_deleted\) \{\n(.|\n)*`)
+	c.Check(app, gc.Matches, `(?s).*  deny /dev/video\* rwklx,\n.*`)
+	c.Check(app, gc.Matches, `(?s).*  deny /dev/snd/\*\* rwklx,\n.*`)
+	c.Check(app, gc.Not(gc.Matches), `(?s).*deny /dev/dri/.*`)
+	c.Check(app, gc.Matches, `(?s).*  deny /etc/\*\* wl,\n.*`)
+	c.Check(app, gc.Matches, `(?s).*  deny /usr/\*\* wl,\n.*`)
+	c.Check(app, gc.Not(gc.Matches), `(?s).*deny /opt/.*`)
+	c.Check(app, gc.Matches, `(?s).*  deny network inet,\n  deny network inet6,.*`)
+
+	install := profileSection(c, profiles, "lxcify-test-install")
+	c.Check(install, gc.Matches, `(?s).*  deny /dev/video\* rwklx,\n.*`)
+	c.Check(install, gc.Matches, `(?s).*  deny network inet,\n.*`)
+	c.Check(install, gc.Not(gc.Matches), `(?s).* wl,.*`)
+}
+
+func (*AppArmorSuite) TestProfileComplain(c *gc.C) {
+	container := newTestContainer(c, AppArmor(&AppArmorProfile{Complain: true}))
+	profiles, err := container.appArmorProfile(nil)
+	c.Assert(err, gc.IsNil)
+	app := profileSection(c, profiles, "lxcify-test")
+	c.Check(app, gc.Matches, `profile lxcify-test flags=\(attach_disconnected,mediate_deleted,complain\) \{\n(.|\n)*`)
+	c.Check(app, gc.Matches, `(?s).*  audit /dev/video\* rwklx,\n.*`)
+	c.Check(app, gc.Matches, `(?s).*  audit /etc/\*\* wl,\n.*`)
+	c.Check(app, gc.Not(gc.Matches), `(?s).*deny.*`)
+}
+
+var testKernelLog = `[ 1.0] audit: type=1400 audit(1.0:1): apparmor="DENIED" operation="open" profile="lxcify-test" name="/dev/video0" pid=1 comm="chrome" requested_mask="r" denied_mask="r"
+[ 2.0] audit: type=1400 audit(2.0:2): apparmor="AUDIT" operation="mknod" profile="lxcify-test" name="/etc/app.conf" pid=1 comm="app" requested_mask="c"
+[ 3.0] audit: type=1400 audit(3.0:3): apparmor="ALLOWED" operation="file_perm" profile="lxcify-test" name="/usr/lib/app/cache" pid=1 comm="app" requested_mask="w"
+[ 4.0] audit: type=1400 audit(4.0:4): apparmor="DENIED" operation="open" profile="lxcify-other" name="/dev/snd/pcmC0D0p" pid=2 comm="app" requested_mask="rw"
+[ 5.0] audit: type=1400 audit(5.0:5): apparmor="STATUS" operation="profile_replace" profile="lxcify-test" name="lxcify-test" pid=3 comm="apparmor_parser"
+[ 6.0] eth0: link up
+`
+
+func (*AppArmorSuite) TestEvents(c *gc.C) {
+	events, err := AppArmorEvents("lxcify-test", strings.NewReader(testKernelLog))
+	c.Assert(err, gc.IsNil)
+	c.Assert(events, gc.DeepEquals, []AppArmorEvent{
+		{"DENIED", "open", "/dev/video0", "r"},
+		{"AUDIT", "mknod", "/etc/app.conf", "c"},
+		{"ALLOWED", "file_perm", "/usr/lib/app/cache", "w"},
+	})
+	c.Assert(SuggestTemplateRules(events), gc.DeepEquals, []string{
+		"apparmor:\n  writable:\n    - /etc",
+		"apparmor:\n  writable:\n    - /usr/lib/app",
+		"mounts:\n  - passthru: /dev/video0",
+	})
+}
@@ -0,0 +1,157 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/juju/errors"
+)
+
+// RateLimit limits traffic to a rate, such as "2mbit", allowing bursts of up
+// to Burst, such as "64kb". Rates and sizes use tc(8) units.
+type RateLimit struct {
+	Rate  string
+	Burst string
+}
+
+// BandwidthLimits limits the traffic a container sends and receives.
+type BandwidthLimits struct {
+	// Egress limits traffic sent by the container.
+	Egress RateLimit
+
+	// Ingress limits traffic received by the container.
+	Ingress RateLimit
+}
+
+// defaultBurst is used when a rate is given without a burst.
+const defaultBurst = "64kb"
+
+var (
+	ratePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?([kmgt]?(bit|bps))$`)
+	sizePattern = regexp.MustCompile(`^[0-9]+([kmg]?b|[kmg]bit)?$`)
+)
+
+func (l RateLimit) validate(direction string) error {
+	if l.Rate == "" {
+		if l.Burst != "" {
+			return errors.Errorf("%s burst requires a rate", direction)
+		}
+		return nil
+	}
+	if !ratePattern.MatchString(l.Rate) {
+		return errors.Errorf("invalid %s rate %q, expected a tc rate such as 2mbit", direction, l.Rate)
+	}
+	if l.Burst != "" && !sizePattern.MatchString(l.Burst) {
+		return errors.Errorf("invalid %s burst %q, expected a tc size such as 64kb", direction, l.Burst)
+	}
+	return nil
+}
+
+func (l RateLimit) burst() string {
+	if l.Burst == "" {
+		return defaultBurst
+	}
+	return l.Burst
+}
+
+func (l BandwidthLimits) validate() error {
+	err := l.Egress.validate("egress")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(l.Ingress.validate("ingress"))
+}
+
+func (l BandwidthLimits) enabled() bool {
+	return l.Egress.Rate != "" || l.Ingress.Rate != ""
+}
+
+// bandwidthUpScript waits in the background for the container's host-side
+// veth to appear on the bridge, which happens after pre-start hooks run, and
+// then shapes it. The veth is named by LXC, so it is found by the container's
+// MAC address in the bridge forwarding database, once the container has sent
+// traffic. Traffic sent by the container arrives on the veth, so egress is
+// policed on its ingress qdisc; traffic received by the container leaves
+// through the veth, so ingress is shaped by its root qdisc.
+const bandwidthUpScript = `#!/bin/sh
+BRIDGE=%[1]q
+HWADDR=${LXCIFY_HWADDR:-%[2]s}
+VETH_FILE=%[3]q
+(
+    for i in $(seq 120); do
+        VETH=$(bridge fdb show br $BRIDGE | awk -v mac=$HWADDR 'tolower($1) == mac && $2 == "dev" {print $3; exit}')
+        if [ -n "$VETH" ]; then
+            echo $VETH >$VETH_FILE
+%[4]s            exit 0
+        fi
+        sleep 0.5
+    done
+    echo "timeout waiting for $HWADDR on $BRIDGE" >&2
+) >>%[5]q 2>&1 &
+`
+
+const bandwidthDownScript = `#!/bin/sh
+VETH_FILE=%q
+VETH=$(cat $VETH_FILE 2>/dev/null)
+rm -f $VETH_FILE
+if [ -n "$VETH" ] && ip link show $VETH >/dev/null 2>&1; then
+    sudo -n tc qdisc del dev $VETH root 2>/dev/null || true
+    sudo -n tc qdisc del dev $VETH ingress 2>/dev/null || true
+fi
+`
+
+// tcCommands returns the tc commands which apply the limits to a veth.
+func (l BandwidthLimits) tcCommands() []string {
+	var cmds []string
+	if l.Egress.Rate != "" {
+		cmds = append(cmds,
+			"sudo -n tc qdisc add dev $VETH handle ffff: ingress",
+			fmt.Sprintf("sudo -n tc filter add dev $VETH parent ffff: protocol all u32 match u32 0 0 "+
+				"police rate %s burst %s drop flowid :1", l.Egress.Rate, l.Egress.burst()))
+	}
+	if l.Ingress.Rate != "" {
+		cmds = append(cmds, fmt.Sprintf("sudo -n tc qdisc add dev $VETH root tbf rate %s burst %s latency 50ms",
+			l.Ingress.Rate, l.Ingress.burst()))
+	}
+	return cmds
+}
+
+// setupBandwidth installs hooks which apply the container's bandwidth limits
+// to its veth while it is running.
+func (c *Container) setupBandwidth() error {
+	err := c.SetConfigItem("lxc.network.hwaddr", c.hwaddr())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var cmds string
+	for _, cmd := range c.network.Limits.tcCommands() {
+		cmds += "            " + cmd + "\n"
+	}
+	dir := path.Join(c.ConfigPath(), c.Name())
+	vethFile := path.Join(dir, "bandwidth.veth")
+	err = c.installHook("pre-start", "bandwidth-up.sh", fmt.Sprintf(bandwidthUpScript,
+		c.network.link(), c.hwaddr(), vethFile, cmds, path.Join(dir, "bandwidth.log")))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.installHook("post-stop", "bandwidth-down.sh",
+		fmt.Sprintf(bandwidthDownScript, vethFile)))
+}
@@ -0,0 +1,98 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+type BandwidthSuite struct{}
+
+var _ = gc.Suite(&BandwidthSuite{})
+
+func (*BandwidthSuite) TestTcCommands(c *gc.C) {
+	limits := BandwidthLimits{
+		Egress:  RateLimit{Rate: "2mbit", Burst: "32kb"},
+		Ingress: RateLimit{Rate: "10mbit"},
+	}
+	c.Assert(limits.tcCommands(), gc.DeepEquals, []string{
+		"sudo -n tc qdisc add dev $VETH handle ffff: ingress",
+		"sudo -n tc filter add dev $VETH parent ffff: protocol all u32 match u32 0 0 " +
+			"police rate 2mbit burst 32kb drop flowid :1",
+		"sudo -n tc qdisc add dev $VETH root tbf rate 10mbit burst 64kb latency 50ms",
+	})
+	c.Assert(BandwidthLimits{}.tcCommands(), gc.HasLen, 0)
+}
+
+// fakeCommand writes a command to dir which logs its arguments to log and
+// prints output.
+func fakeCommand(c *gc.C, dir, name, log, output string) {
+	script := fmt.Sprintf("#!/bin/sh\necho %s \"$@\" >>%s\nprintf '%%s' '%s'\n", name, log, output)
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0700)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*BandwidthSuite) TestUpScript(c *gc.C) {
+	dir := c.MkDir()
+	binDir, log := filepath.Join(dir, "bin"), filepath.Join(dir, "commands.log")
+	err := os.Mkdir(binDir, 0700)
+	c.Assert(err, gc.IsNil)
+	fakeCommand(c, binDir, "bridge", log, "33:33:00:00:00:01 dev lxcbr0 self permanent\n"+
+		"00:16:3e:00:00:02 dev veth1234 master lxcbr0\n"+
+		"00:16:3e:a9:4a:8f dev vethABCD master lxcbr0\n")
+	fakeCommand(c, binDir, "sudo", log, "")
+
+	limits := BandwidthLimits{Ingress: RateLimit{Rate: "1mbit"}}
+	var cmds string
+	for _, cmd := range limits.tcCommands() {
+		cmds += "            " + cmd + "\n"
+	}
+	vethFile := filepath.Join(dir, "bandwidth.veth")
+	script := fmt.Sprintf(bandwidthUpScript, "lxcbr0", "00:16:3e:a9:4a:8f", vethFile, cmds,
+		filepath.Join(dir, "bandwidth.log"))
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":"+os.Getenv("PATH"))
+	err = cmd.Run()
+	c.Assert(err, gc.IsNil)
+
+	var veth []byte
+	for i := 0; i < 50; i++ {
+		if veth, err = ioutil.ReadFile(vethFile); err == nil && len(veth) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(strings.TrimSpace(string(veth)), gc.Equals, "vethABCD")
+	var commands []byte
+	for i := 0; i < 50; i++ {
+		if commands, _ = ioutil.ReadFile(log); strings.Contains(string(commands), "sudo") {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(string(commands), gc.Equals, "bridge fdb show br lxcbr0\n"+
+		"sudo -n tc qdisc add dev vethABCD root tbf rate 1mbit burst 64kb latency 50ms\n")
+}
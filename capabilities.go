@@ -0,0 +1,177 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// CapabilitySet describes the capabilities dropped from, or kept by, the
+// container root user.
+type CapabilitySet struct {
+	// Preset names a base set of dropped capabilities. "hardened" drops
+	// capabilities desktop applications have no need for, and is the
+	// default unless Keep is given; "none" drops nothing.
+	Preset string
+
+	// Drop lists capabilities to drop, in addition to the preset. It may not
+	// be combined with Keep.
+	Drop []string
+
+	// Keep lists the only capabilities to keep, dropping all others. It may
+	// not be combined with Drop or a preset.
+	Keep []string
+}
+
+// capabilityNames lists capabilities in the order of their kernel numbering,
+// in the form LXC expects them.
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+// defaultCapabilityPreset is applied to containers unless their
+// capabilities are configured otherwise.
+const defaultCapabilityPreset = "hardened"
+
+var capabilityPresets = map[string][]string{
+	"none": nil,
+	"hardened": {
+		"audit_control", "audit_read", "block_suspend", "lease",
+		"linux_immutable", "mac_admin", "mac_override", "mknod", "sys_boot",
+		"sys_module", "sys_nice", "sys_pacct", "sys_rawio", "sys_time",
+		"syslog", "wake_alarm",
+	},
+}
+
+// CapabilityPresets returns the names of the capability presets, sorted.
+func CapabilityPresets() []string {
+	var names []string
+	for name := range capabilityPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// knownCapabilities returns the capabilities known to the running kernel.
+func knownCapabilities() map[string]bool {
+	names := capabilityNames
+	contents, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		last, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+		if err == nil && last+1 < len(names) {
+			names = names[:last+1]
+		}
+	}
+	known := make(map[string]bool)
+	for _, name := range names {
+		known[name] = true
+	}
+	return known
+}
+
+// normalizeCapability converts a capability name such as CAP_SYS_ADMIN into
+// the form LXC expects, sys_admin.
+func normalizeCapability(name string) string {
+	return strings.TrimPrefix(strings.ToLower(name), "cap_")
+}
+
+// Capabilities sets the capabilities dropped from, or kept by, the container
+// root user. A nil set applies the default preset.
+func Capabilities(caps *CapabilitySet) Option {
+	return func(c *Container) error {
+		if caps == nil {
+			caps = &CapabilitySet{}
+		}
+		drop, keep, err := caps.resolve(knownCapabilities())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		c.capDrop, c.capKeep = drop, keep
+		return nil
+	}
+}
+
+// resolve returns the capabilities to drop and keep, given those known to
+// the kernel. Preset capabilities the kernel doesn't know are left out.
+func (caps *CapabilitySet) resolve(known map[string]bool) (drop, keep []string, err error) {
+	presetName := caps.Preset
+	if presetName == "" {
+		presetName = defaultCapabilityPreset
+		if len(caps.Keep) > 0 {
+			presetName = "none"
+		}
+	}
+	preset, ok := capabilityPresets[presetName]
+	if !ok {
+		return nil, nil, errors.Errorf("unknown capabilities preset %q", caps.Preset)
+	}
+	for _, name := range preset {
+		if known[name] {
+			drop = append(drop, name)
+		}
+	}
+	normalize := func(names []string) ([]string, error) {
+		var result []string
+		for _, name := range names {
+			capName := normalizeCapability(name)
+			if !known[capName] {
+				return nil, errors.Errorf("unknown capability %q", name)
+			}
+			result = append(result, capName)
+		}
+		return result, nil
+	}
+	extra, err := normalize(caps.Drop)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	drop = append(drop, extra...)
+	if len(caps.Keep) == 1 && caps.Keep[0] == "none" {
+		keep = caps.Keep
+	} else if keep, err = normalize(caps.Keep); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if len(drop) > 0 && len(keep) > 0 {
+		return nil, nil, errors.New("capabilities drop and keep are mutually exclusive")
+	}
+	return drop, keep, nil
+}
+
+func (c *Container) capabilityConfig() []lxcConfigItem {
+	var items []lxcConfigItem
+	if len(c.capDrop) > 0 {
+		items = append(items, lxcConfigItem{"lxc.cap.drop", strings.Join(c.capDrop, " ")})
+	}
+	if len(c.capKeep) > 0 {
+		items = append(items, lxcConfigItem{"lxc.cap.keep", strings.Join(c.capKeep, " ")})
+	}
+	return items
+}
@@ -0,0 +1,90 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+type CapabilitiesSuite struct{}
+
+var _ = gc.Suite(&CapabilitiesSuite{})
+
+func (*CapabilitiesSuite) TestResolve(c *gc.C) {
+	known := map[string]bool{
+		"chown": true, "setuid": true, "mknod": true, "sys_module": true, "net_raw": true,
+	}
+	testCases := []struct {
+		caps       CapabilitySet
+		drop, keep []string
+		errPattern string
+	}{{
+		caps: CapabilitySet{},
+		drop: []string{"mknod", "sys_module"},
+	}, {
+		caps: CapabilitySet{Preset: "hardened", Drop: []string{"CAP_NET_RAW"}},
+		drop: []string{"mknod", "sys_module", "net_raw"},
+	}, {
+		caps: CapabilitySet{Preset: "none"},
+	}, {
+		caps: CapabilitySet{Preset: "none", Drop: []string{"net_raw"}},
+		drop: []string{"net_raw"},
+	}, {
+		caps: CapabilitySet{Keep: []string{"chown", "CAP_SETUID"}},
+		keep: []string{"chown", "setuid"},
+	}, {
+		caps: CapabilitySet{Keep: []string{"none"}},
+		keep: []string{"none"},
+	}, {
+		caps:       CapabilitySet{Preset: "hardened", Keep: []string{"chown"}},
+		errPattern: "capabilities drop and keep are mutually exclusive",
+	}, {
+		caps:       CapabilitySet{Drop: []string{"sys_time"}},
+		errPattern: `unknown capability "sys_time"`,
+	}, {
+		caps:       CapabilitySet{Preset: "paranoid"},
+		errPattern: `unknown capabilities preset "paranoid"`,
+	}}
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		drop, keep, err := testCase.caps.resolve(known)
+		if testCase.errPattern != "" {
+			c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(drop, gc.DeepEquals, testCase.drop)
+		c.Assert(keep, gc.DeepEquals, testCase.keep)
+	}
+}
+
+func (*CapabilitiesSuite) TestConfig(c *gc.C) {
+	container := newTestContainer(c)
+	items := container.capabilityConfig()
+	c.Assert(items, gc.HasLen, 1)
+	c.Assert(items[0].key, gc.Equals, "lxc.cap.drop")
+	c.Assert(items[0].value, gc.Matches, `(.* )?sys_module( .*)?`)
+
+	container = newTestContainer(c, Capabilities(&CapabilitySet{Keep: []string{"chown", "setuid"}}))
+	c.Assert(container.capabilityConfig(), gc.DeepEquals, []lxcConfigItem{
+		{"lxc.cap.keep", "chown setuid"},
+	})
+
+	container = newTestContainer(c, Capabilities(&CapabilitySet{Preset: "none"}))
+	c.Assert(container.capabilityConfig(), gc.HasLen, 0)
+}
@@ -0,0 +1,83 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var apparmorFlags = register("apparmor", "report apparmor denials and suggest template rules", runAppArmor)
+
+var apparmorName string
+
+func init() {
+	apparmorFlags.StringVar(&apparmorName, "name", "", "container name")
+}
+
+// kernelLog returns the kernel log from the journal, or from dmesg on systems
+// without one.
+func kernelLog() ([]byte, error) {
+	out, err := exec.Command("journalctl", "-k", "-o", "cat", "--no-pager").Output()
+	if err == nil {
+		return out, nil
+	}
+	out, err = exec.Command("dmesg").Output()
+	return out, errors.Annotate(err, "cannot read kernel log")
+}
+
+func runAppArmor(args []string) error {
+	if apparmorName == "" {
+		log.Println("missing required flag -name")
+		commandUsage(commands["apparmor"])
+	}
+	c, err := lxcify.NewContainer(apparmorName, lxcify.ConfigPath(lxc.DefaultConfigPath()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	out, err := kernelLog()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	events, err := lxcify.AppArmorEvents(c.AppArmorProfileName(), bytes.NewReader(out))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(events) == 0 {
+		fmt.Printf("no apparmor events for %s\n", c.AppArmorProfileName())
+		return nil
+	}
+	for _, event := range events {
+		fmt.Printf("%s %s %s %s\n", event.Status, event.Operation, event.Mask, event.Name)
+	}
+	suggestions := lxcify.SuggestTemplateRules(events)
+	if len(suggestions) > 0 {
+		fmt.Println("\nsuggested template rules:")
+		for _, suggestion := range suggestions {
+			fmt.Printf("\n%s\n", suggestion)
+		}
+	}
+	return nil
+}
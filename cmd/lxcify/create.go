@@ -0,0 +1,157 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v1"
+
+	"github.com/cmars/lxcify/template"
+)
+
+var createFlags = register("create", "create a container from an app config file", runCreate)
+
+var (
+	config       string
+	templateName string
+	name         string
+	complain     bool
+	setValues    = make(setFlag)
+	valuesFile   string
+)
+
+// setFlag collects repeated -set key=value flags.
+type setFlag map[string]string
+
+func (f setFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f setFlag) Set(s string) error {
+	fields := strings.SplitN(s, "=", 2)
+	if len(fields) != 2 || fields[0] == "" {
+		return errors.Errorf("invalid value %q, expected key=value", s)
+	}
+	f[fields[0]] = fields[1]
+	return nil
+}
+
+// parameterValues returns template parameter values from the values file, if
+// any, overridden by -set flags.
+func parameterValues() (map[string]string, error) {
+	values := make(map[string]string)
+	if valuesFile != "" {
+		in, err := ioutil.ReadFile(valuesFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		fileValues := make(map[string]interface{})
+		err = yaml.Unmarshal(in, &fileValues)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid values file %q", valuesFile)
+		}
+		for k, v := range fileValues {
+			values[k] = fmt.Sprint(v)
+		}
+	}
+	for k, v := range setValues {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func init() {
+	createFlags.StringVar(&config, "config", "", "app config file")
+	createFlags.StringVar(&templateName, "template", "", "name of a template in the template search path")
+	createFlags.StringVar(&name, "name", "", "container name")
+	createFlags.BoolVar(&complain, "complain", false, "generate an apparmor profile in complain mode")
+	createFlags.BoolVar(&lenient, "lenient", false, "warn about unknown template keys rather than fail")
+	createFlags.Var(setValues, "set", "set a template parameter, as key=value (repeatable)")
+	createFlags.StringVar(&valuesFile, "values", "", "YAML file of template parameter values")
+}
+
+func runCreate(args []string) error {
+	if (config == "") == (templateName == "") {
+		log.Println("expected one of -config or -template")
+		commandUsage(commands["create"])
+	}
+	if name == "" {
+		log.Println("missing required flag -name")
+		commandUsage(commands["create"])
+	}
+
+	values, err := parameterValues()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if templateName != "" {
+		config, err = template.Find(templateName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	t, err := template.ParseFile(config, append(parseOptions(), template.Values(values))...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if complain {
+		t.ComplainAppArmor()
+	}
+
+	return errors.Trace(createContainer(t, name))
+}
+
+// createContainer creates a container from a template and installs its app,
+// leaving it stopped.
+func createContainer(t *template.Template, name string) error {
+	c, err := t.Container(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	app, err := t.App()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = c.Create()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = c.Install(app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = c.Stop()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return nil
+}
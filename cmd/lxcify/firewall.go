@@ -0,0 +1,55 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var firewallFlags = register("firewall", "show container firewall rules: firewall show <name>", runFirewall)
+
+func runFirewall(args []string) error {
+	if len(args) != 2 || args[0] != "show" {
+		log.Println("expected: firewall show <name>")
+		commandUsage(commands["firewall"])
+	}
+	c, err := lxcify.NewContainer(args[1], lxcify.ConfigPath(lxc.DefaultConfigPath()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rules, active, err := c.FirewallRules()
+	if errors.IsNotFound(err) {
+		fmt.Printf("%s has no firewall\n", args[1])
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	if active {
+		fmt.Printf("# active rules for %s\n", args[1])
+	} else {
+		fmt.Printf("# %s is not running, rules applied when it starts\n", args[1])
+	}
+	fmt.Print(rules)
+	return nil
+}
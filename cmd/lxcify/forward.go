@@ -0,0 +1,41 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var forwardFlags = register("forward", "forward host ports to a container, run by its hooks: forward <name>", runForward)
+
+func runForward(args []string) error {
+	if len(args) != 1 {
+		log.Println("expected: forward <name>")
+		commandUsage(commands["forward"])
+	}
+	c, err := lxcify.NewContainer(args[0], lxcify.ConfigPath(lxc.DefaultConfigPath()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.Forward(nil))
+}
@@ -0,0 +1,76 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var limitsFlags = register("limits",
+	"show or change container resource limits: limits <name> [memory=2G cpu-quota=1.5 ...]", runLimits)
+
+func runLimits(args []string) error {
+	if len(args) < 1 {
+		log.Println("expected: limits <name> [key=value ...]")
+		commandUsage(commands["limits"])
+	}
+	c, err := lxcify.NewContainer(args[0], lxcify.ConfigPath(lxc.DefaultConfigPath()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !c.Defined() {
+		return errors.NotFoundf("container %q", args[0])
+	}
+
+	if len(args) > 1 {
+		var r lxcify.Resources
+		for _, arg := range args[1:] {
+			fields := strings.SplitN(arg, "=", 2)
+			if len(fields) != 2 {
+				return errors.Errorf("invalid limit %q, expected key=value", arg)
+			}
+			err = r.Set(fields[0], fields[1])
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		err = c.SetResources(&r)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	limits := c.CgroupLimits()
+	var keys []string
+	for key := range limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s: %s\n", key, limits[key])
+	}
+	return nil
+}
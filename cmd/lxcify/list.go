@@ -0,0 +1,68 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var listFlags = register("list", "list lxcify containers", runList)
+
+func runList(args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tADDRESSES\tPORTS")
+	for _, name := range lxcify.ContainerNames(lxc.DefaultConfigPath()) {
+		c, err := lxcify.NewContainer(name, lxcify.ConfigPath(lxc.DefaultConfigPath()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var addrs []string
+		if c.Running() {
+			addrs, err = c.IPv4Addresses()
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		forwards, err := c.PortForwards()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var ports []string
+		for _, forward := range forwards {
+			ports = append(ports, forward.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, c.State(),
+			orDash(strings.Join(addrs, ",")), orDash(strings.Join(ports, ",")))
+	}
+	return errors.Trace(w.Flush())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
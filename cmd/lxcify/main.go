@@ -19,23 +19,43 @@ package main
 
 import (
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"os"
-
-	"github.com/juju/errors"
+	"sort"
+	"strings"
 
 	"github.com/cmars/lxcify/template"
 )
 
-var (
-	config string
-	name   string
-)
+// command is an lxcify subcommand.
+type command struct {
+	name    string
+	summary string
+	flags   *flag.FlagSet
+	run     func(args []string) error
+}
 
-func init() {
-	flag.StringVar(&config, "config", "", "app config file")
-	flag.StringVar(&name, "name", "", "container name")
+var commands = make(map[string]*command)
+
+func register(name, summary string, run func(args []string) error) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	commands[name] = &command{name, summary, flags, run}
+	return flags
+}
+
+// lenient is set by commands which parse templates, to warn about unknown
+// keys rather than fail.
+var lenient bool
+
+// parseOptions returns the template parse options selected by flags.
+func parseOptions() []template.ParseOption {
+	if !lenient {
+		return nil
+	}
+	return []template.ParseOption{template.Lenient(func(msg string) {
+		log.Printf("warning: %s", msg)
+	})}
 }
 
 func die(err error) {
@@ -45,79 +65,41 @@ func die(err error) {
 	os.Exit(0)
 }
 
-func parseFlags() {
-	flag.Parse()
-	if config == "" {
-		log.Println("missing required flag -config")
-		usage()
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
-	if name == "" {
-		log.Println("missing required flag -name")
-		usage()
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
 	}
+	os.Exit(1)
 }
 
-func usage() {
-	flag.PrintDefaults()
+func commandUsage(cmd *command) {
+	fmt.Fprintf(os.Stderr, "usage: %s %s [flags]\n", os.Args[0], cmd.name)
+	cmd.flags.PrintDefaults()
 	os.Exit(1)
 }
 
 func main() {
-	parseFlags()
-
-	err := run()
-	if err != nil {
-		die(err)
-	}
-}
-
-func run() error {
-	var conf []byte
-	var err error
-
-	f, err := os.Open(config)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	defer f.Close()
-	conf, err = ioutil.ReadAll(f)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	t, err := template.Parse(conf)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	c, err := t.Container(name)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	app, err := t.App()
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	err = c.Create()
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	err = c.Start()
-	if err != nil {
-		return errors.Trace(err)
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "-help") {
+		usage()
 	}
-
-	err = c.Install(app)
-	if err != nil {
-		return errors.Trace(err)
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		// lxcify -config <file> -name <name> predates subcommands.
+		args = append([]string{"create"}, args...)
 	}
-
-	err = c.Stop()
-	if err != nil {
-		return errors.Trace(err)
+	cmd, ok := commands[args[0]]
+	if !ok {
+		log.Printf("unknown command %q", args[0])
+		usage()
 	}
+	cmd.flags.Usage = func() { commandUsage(cmd) }
+	cmd.flags.Parse(args[1:])
 
-	return nil
+	die(cmd.run(cmd.flags.Args()))
 }
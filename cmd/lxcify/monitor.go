@@ -0,0 +1,64 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var monitorFlags = register("monitor", "report camera and microphone use by containers", runMonitor)
+
+var (
+	monitorInterval time.Duration
+	monitorNotify   bool
+)
+
+func init() {
+	monitorFlags.DurationVar(&monitorInterval, "interval", 2*time.Second, "polling interval")
+	monitorFlags.BoolVar(&monitorNotify, "notify", false, "raise desktop notifications with notify-send")
+}
+
+func runMonitor(args []string) error {
+	m := lxcify.NewMonitor(lxc.DefaultConfigPath(), monitorInterval)
+	events := make(chan lxcify.CaptureEvent)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- m.Run(events, nil)
+	}()
+	for {
+		select {
+		case event := <-events:
+			fmt.Printf("%s %s\n", event.Time.Format(time.RFC3339), event)
+			if monitorNotify {
+				err := exec.Command("notify-send", "-a", "lxcify", "lxcify", event.String()).Run()
+				if err != nil {
+					return errors.Annotate(err, "notify-send failed")
+				}
+			}
+		case err := <-errs:
+			return errors.Trace(err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+	"github.com/cmars/lxcify/template"
+)
+
+var (
+	upFlags   = register("up", "create and start the containers in a pod: up <pod file>", runUp)
+	downFlags = register("down", "stop the containers in a pod: down <pod file>", runDown)
+)
+
+func init() {
+	upFlags.BoolVar(&lenient, "lenient", false, "warn about unknown template keys rather than fail")
+}
+
+func runUp(args []string) error {
+	if len(args) != 1 {
+		log.Println("expected: up <pod file>")
+		commandUsage(commands["up"])
+	}
+	pod, err := template.ParsePodFile(args[0], parseOptions()...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	order, err := pod.Order()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range order {
+		t, err := pod.Template(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		c, err := t.Container(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !c.Defined() {
+			log.Printf("creating %s", name)
+			err = createContainer(t, name)
+			if err != nil {
+				return errors.Annotatef(err, "cannot create %q", name)
+			}
+			// Creating the container defines it with another handle.
+			c, err = t.Container(name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if !c.Running() {
+			log.Printf("starting %s", name)
+			err = c.Start()
+			if err != nil {
+				return errors.Annotatef(err, "cannot start %q", name)
+			}
+		}
+
+		links, err := pod.Links(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		hosts := make(map[string]string)
+		for _, link := range links {
+			linked, err := lxcify.NewContainer(link, lxcify.ConfigPath(lxc.DefaultConfigPath()))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			addrs, err := linked.IPv4Addresses()
+			if err != nil || len(addrs) == 0 {
+				return errors.Errorf("cannot link %q to %q: no address", name, link)
+			}
+			hosts[link] = addrs[0]
+		}
+		if len(hosts) > 0 {
+			err = c.SetHosts(hosts)
+			if err != nil {
+				return errors.Annotatef(err, "cannot link %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+func runDown(args []string) error {
+	if len(args) != 1 {
+		log.Println("expected: down <pod file>")
+		commandUsage(commands["down"])
+	}
+	// Unknown keys shouldn't stop a pod from being brought down.
+	pod, err := template.ParsePodFile(args[0], template.Lenient(func(string) {}))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	order, err := pod.Order()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		c, err := lxcify.NewContainer(order[i], lxcify.ConfigPath(lxc.DefaultConfigPath()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !c.Running() {
+			continue
+		}
+		log.Printf("stopping %s", order[i])
+		err = c.Stop()
+		if err != nil {
+			return errors.Annotatef(err, "cannot stop %q", order[i])
+		}
+	}
+	return nil
+}
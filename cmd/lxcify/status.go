@@ -0,0 +1,67 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+
+	"github.com/cmars/lxcify"
+)
+
+var statusFlags = register("status", "show container status: status <name>", runStatus)
+
+func runStatus(args []string) error {
+	if len(args) != 1 {
+		log.Println("expected: status <name>")
+		commandUsage(commands["status"])
+	}
+	c, err := lxcify.NewContainer(args[0], lxcify.ConfigPath(lxc.DefaultConfigPath()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !c.Defined() {
+		return errors.NotFoundf("container %q", args[0])
+	}
+	fmt.Printf("state: %s\n", c.State())
+	if !c.Running() {
+		return nil
+	}
+
+	addrs, err := c.IPAddresses()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("addresses: %s\n", strings.Join(addrs, " "))
+
+	tor, err := c.TorStatus()
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		fmt.Printf("tor: %v\n", err)
+		return nil
+	}
+	fmt.Printf("tor bootstrap: %s\n", tor.Bootstrap)
+	fmt.Printf("tor circuit established: %v\n", tor.CircuitEstablished)
+	fmt.Printf("tor circuits built: %d\n", tor.BuiltCircuits)
+	return nil
+}
@@ -0,0 +1,141 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v1"
+
+	"github.com/cmars/lxcify/template"
+)
+
+var templateFlags = register("template", "work with templates: template show|migrate <file or name>, template schema", runTemplate)
+
+var writeMigrated bool
+
+func init() {
+	templateFlags.BoolVar(&writeMigrated, "w", false, "write migrated templates in place, rather than show the changes")
+	templateFlags.BoolVar(&lenient, "lenient", false, "warn about unknown template keys rather than fail")
+	templateFlags.Var(setValues, "set", "set a template parameter, as key=value (repeatable)")
+	templateFlags.StringVar(&valuesFile, "values", "", "YAML file of template parameter values")
+}
+
+func runTemplate(args []string) error {
+	if len(args) < 1 {
+		log.Println("expected: template show|migrate <file or name>, or template schema")
+		commandUsage(commands["template"])
+	}
+	switch args[0] {
+	case "show":
+		return errors.Trace(runTemplateShow(args[1:]))
+	case "migrate":
+		return errors.Trace(runTemplateMigrate(args[1:]))
+	case "schema":
+		return errors.Trace(runTemplateSchema(args[1:]))
+	}
+	log.Printf("unknown template command %q", args[0])
+	commandUsage(commands["template"])
+	return nil
+}
+
+// runTemplateShow prints a template with the templates it extends merged in.
+func runTemplateShow(args []string) error {
+	if len(args) != 1 {
+		log.Println("expected: template show <file or name>")
+		commandUsage(commands["template"])
+	}
+	values, err := parameterValues()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	filename, err := template.Resolve(args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	t, err := template.ParseFile(filename, append(parseOptions(), template.Values(values))...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	out, err := yaml.Marshal(t)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Print(string(out))
+	return nil
+}
+
+// runTemplateMigrate shows the changes needed to bring templates to the
+// current schema version, or makes them with -w.
+func runTemplateMigrate(args []string) error {
+	if len(args) < 1 {
+		log.Println("expected: template [-w] migrate <file or name>...")
+		commandUsage(commands["template"])
+	}
+	for _, arg := range args {
+		filename, err := template.Resolve(arg)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		in, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		out, changed, err := template.Migrate(in)
+		if err != nil {
+			return errors.Annotate(err, filename)
+		}
+		if !changed {
+			log.Printf("%s: already at schema-version %d", filename, template.SchemaVersion)
+			continue
+		}
+		if !writeMigrated {
+			fmt.Printf("--- %s\n+++ %s (schema-version %d)\n", filename, filename, template.SchemaVersion)
+			fmt.Print(unifiedDiff(string(in), string(out)))
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = ioutil.WriteFile(filename, out, info.Mode())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		log.Printf("%s: migrated to schema-version %d", filename, template.SchemaVersion)
+	}
+	return nil
+}
+
+// runTemplateSchema prints the JSON Schema of the template format.
+func runTemplateSchema(args []string) error {
+	if len(args) != 0 {
+		log.Println("expected: template schema")
+		commandUsage(commands["template"])
+	}
+	out, err := template.Schema()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Print(string(out))
+	return nil
+}
@@ -0,0 +1,48 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juju/errors"
+
+	"github.com/cmars/lxcify/template"
+)
+
+var templatesFlags = register("templates", "list templates in the template search path", runTemplates)
+
+func runTemplates(args []string) error {
+	entries, err := template.Catalog()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(entries) == 0 {
+		fmt.Printf("no templates found in %s\n", strings.Join(template.SearchPath(), ":"))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDESCRIPTION\tSOURCE")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Name, orDash(entry.Description), entry.Path)
+	}
+	return errors.Trace(w.Flush())
+}
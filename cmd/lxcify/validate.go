@@ -0,0 +1,66 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/juju/errors"
+
+	"github.com/cmars/lxcify/template"
+)
+
+var validateFlags = register("validate", "check templates for problems: validate <file or name> ...", runValidate)
+
+func init() {
+	validateFlags.BoolVar(&lenient, "lenient", false, "warn about unknown template keys rather than fail")
+	validateFlags.Var(setValues, "set", "set a template parameter, as key=value (repeatable)")
+	validateFlags.StringVar(&valuesFile, "values", "", "YAML file of template parameter values")
+}
+
+func runValidate(args []string) error {
+	if len(args) == 0 {
+		log.Println("expected: validate <file or name> ...")
+		commandUsage(commands["validate"])
+	}
+	values, err := parameterValues()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	failed := 0
+	for _, filename := range args {
+		var issues []template.Issue
+		resolved, err := template.Resolve(filename)
+		if err != nil {
+			issues = []template.Issue{{Message: err.Error()}}
+		} else {
+			issues = template.ValidateFile(resolved, append(parseOptions(), template.Values(values))...)
+		}
+		for _, issue := range issues {
+			fmt.Printf("%s: %s\n", filename, issue)
+		}
+		if template.HasErrors(issues) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d templates invalid", failed, len(args))
+	}
+	return nil
+}
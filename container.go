@@ -19,7 +19,8 @@ package lxcify
 
 import (
 	"fmt"
-	"time"
+	"os"
+	"path"
 
 	"github.com/juju/errors"
 	"github.com/juju/loggo"
@@ -31,6 +32,7 @@ var logger = loggo.GetLogger("lxcify")
 type Mount struct {
 	Host, Container string
 	IsDir           bool
+	ReadOnly        bool
 }
 
 func (m Mount) lxcConfigItem() lxcConfigItem {
@@ -38,8 +40,12 @@ func (m Mount) lxcConfigItem() lxcConfigItem {
 	if m.IsDir {
 		create = "dir"
 	}
-	return lxcConfigItem{"lxc.mount.entry", fmt.Sprintf("%s %s none bind,optional,create=%s",
-		m.Host, m.Container, create)}
+	options := "bind"
+	if m.ReadOnly {
+		options += ",ro"
+	}
+	return lxcConfigItem{"lxc.mount.entry", fmt.Sprintf("%s %s none %s,optional,create=%s",
+		m.Host, m.Container, options, create)}
 }
 
 func PassthruMount(path string, isDir bool) Mount {
@@ -80,6 +86,25 @@ type Container struct {
 
 	mounts     []Mount
 	pulseAudio bool
+	gpu        GPUDriver
+	apparmor   *AppArmorProfile
+	seccomp    *SeccompPolicy
+	capDrop    []string
+	capKeep    []string
+	network    NetworkPolicy
+	firewall   []FirewallRule
+	dns        DNSPolicy
+	identity   IdentityMode
+	ports      []PortForward
+	vpn        *VPNProfile
+	resources  *Resources
+
+	// firewallSet is whether the Firewall option was given, which enables
+	// the firewall even without rules.
+	firewallSet bool
+
+	// vpnEndpoints are the resolved VPN endpoints, once installed.
+	vpnEndpoints []vpnEndpoint
 }
 
 type Option func(*Container) error
@@ -127,28 +152,94 @@ func PulseAudio(enable bool) Option {
 	}
 }
 
-func NewContainer(name string, options ...Option) (*Container, error) {
-	c := &Container{}
+func GPU(driver GPUDriver) Option {
+	return func(c *Container) error {
+		switch driver {
+		case DefaultGPU, NvidiaGPU:
+		default:
+			return errors.Errorf("unsupported gpu %q", driver)
+		}
+		c.gpu = driver
+		return nil
+	}
+}
 
+func NewContainer(name string, options ...Option) (*Container, error) {
 	if len(options) == 0 {
 		options = defaultOptions
 	}
 
-	var err error
+	c, err := applyOptions(options)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	c.Container, err = lxc.NewContainer(name, c.lxcpath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c, nil
+}
+
+func applyOptions(options []Option) (*Container, error) {
+	c := &Container{}
+	// The default capabilities preset applies unless configured otherwise.
+	err := Capabilities(nil)(c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	for _, option := range options {
 		err = option(c)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
 	}
-
-	c.Container, err = lxc.NewContainer(name, c.lxcpath)
+	err = c.validate()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	return c, nil
 }
 
+// CheckOptions checks that options are valid and consistent with each other,
+// without creating a container.
+func CheckOptions(options ...Option) error {
+	_, err := applyOptions(options)
+	return errors.Trace(err)
+}
+
+// validate checks that options are consistent with each other.
+func (c *Container) validate() error {
+	if c.dns.Mode != DNSDefault {
+		switch c.network.Mode {
+		case NetworkNone, NetworkTor:
+			return errors.Errorf("dns %q is not supported in %q network mode", c.dns.Mode, c.network.Mode)
+		}
+	}
+	if c.vpn != nil {
+		switch c.network.Mode {
+		case NetworkNone, NetworkProxy, NetworkTor:
+			return errors.Errorf("vpn is not supported in %q network mode", c.network.Mode)
+		}
+		if c.dns.Mode != DNSDefault {
+			return errors.Errorf("dns %q is not supported with a vpn", c.dns.Mode)
+		}
+		if len(c.firewall) > 0 {
+			return errors.New("firewall rules are not supported with a vpn")
+		}
+	}
+	if c.seccomp != nil {
+		err := c.seccomp.checkArch(c.arch)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(c.ports) > 0 && c.network.Mode == NetworkNone {
+		return errors.Errorf("ports are not supported in %q network mode", c.network.Mode)
+	}
+	return nil
+}
+
 func (c *Container) setLxcConfig(items []lxcConfigItem) error {
 	for _, item := range items {
 		err := c.SetConfigItem(item.key, item.value)
@@ -168,8 +259,33 @@ func (c *Container) Start() error {
 	if !ok {
 		return errors.New("timeout waiting for container to start")
 	}
-
-	// TODO: poll for container network availability, sleeps suck
-	time.Sleep(5 * time.Second)
+	err = c.verifyNetwork()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The VPN kill switch is added to the firewall at install time, so the
+	// firewall is verified whenever one has been written.
+	if _, err := os.Stat(c.firewallFile()); err == nil {
+		return errors.Trace(c.verifyFirewall(c.hwaddr()))
+	}
 	return nil
 }
+
+// isLxcified returns whether a container was set up by lxcify, which leaves a
+// launcher script in the container directory.
+func isLxcified(lxcpath, name string) bool {
+	_, err := os.Stat(path.Join(lxcpath, name, "launch.sh"))
+	return err == nil
+}
+
+// ContainerNames returns the names of the containers in lxcpath which were
+// set up by lxcify.
+func ContainerNames(lxcpath string) []string {
+	var names []string
+	for _, name := range lxc.DefinedContainerNames(lxcpath) {
+		if isLxcified(lxcpath, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
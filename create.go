@@ -42,16 +42,101 @@ func (c *Container) Create() error {
 		return errors.Trace(err)
 	}
 
+	err = c.setupNetwork()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = c.setupDNS()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	mounts := c.mounts
+	if c.gpu == NvidiaGPU {
+		gpuMounts, err := nvidiaMounts(c.arch)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mounts = append(mounts, gpuMounts...)
+	}
+	if c.identity == EphemeralIdentity {
+		err = c.setupIdentity()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mounts = append(mounts, c.identityMounts()...)
+	}
+	if c.vpn != nil {
+		mounts = append(mounts, c.vpnMounts()...)
+	}
+
 	var configItems []lxcConfigItem
 	configItems = append(configItems, defaultLxcConfig...)
-	for _, mount := range c.mounts {
+	for _, mount := range mounts {
 		configItems = append(configItems, mount.lxcConfigItem())
 	}
+	configItems = append(configItems, c.capabilityConfig()...)
+	resourceItems, err := c.resourceConfig()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	configItems = append(configItems, resourceItems...)
 	err = c.setLxcConfig(configItems)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	if c.apparmor != nil {
+		err = c.setupAppArmor(mounts)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if c.seccomp != nil {
+		err = c.setupSeccomp()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if c.network.Mode == NetworkTor {
+		// The firewall redirects to the Tor client's ports.
+		err = c.assignTorPorts()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if c.firewallEnabled() {
+		err = c.setupFirewall()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if c.network.Limits.enabled() {
+		err = c.setupBandwidth()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if c.network.Mode == NetworkTor {
+		err = c.setupTor()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(c.ports) > 0 {
+		err = c.setupPorts()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	if c.pulseAudio {
 		err = c.setupPulseAudio()
 		if err != nil {
@@ -99,14 +184,19 @@ func clearIdMap(c *lxc.Container) error {
 	return c.LoadConfigFile(c.ConfigFileName())
 }
 
+// Container ids are mapped onto this range of host ids, except for the user's
+// own ids, which are passed through.
+const (
+	startLxcId = 100000
+	nLxcIds    = 65535
+)
+
 func (c *Container) setupUserPassthru() error {
 	err := clearIdMap(c.Container)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	startLxcId := 100000
-	nLxcIds := 65535
 	uid, gid := os.Getuid(), os.Getgid()
 	items := []lxcConfigItem{
 		{"lxc.id_map", fmt.Sprintf("u 0 %d %d", startLxcId, uid)},
@@ -137,15 +227,22 @@ fi
 `
 
 func (c *Container) setupPulseAudio() error {
-	f, err := os.OpenFile(path.Join(c.ConfigPath(), c.Name(), "setup-pulse.sh"),
+	return errors.Trace(c.installHook("pre-start", "setup-pulse.sh", setupPulseScript))
+}
+
+// installHook writes a hook script into the container directory and adds it
+// to the container config. Hooks run on the host, as the user starting the
+// container.
+func (c *Container) installHook(hook, filename, script string) error {
+	f, err := os.OpenFile(path.Join(c.ConfigPath(), c.Name(), filename),
 		os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer f.Close()
-	_, err = fmt.Fprint(f, setupPulseScript)
+	_, err = fmt.Fprint(f, script)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return errors.Trace(c.SetConfigItem("lxc.hook.pre-start", f.Name()))
+	return errors.Trace(c.SetConfigItem("lxc.hook."+hook, f.Name()))
 }
@@ -0,0 +1,273 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// DNSMode selects how a container resolves names.
+type DNSMode string
+
+const (
+	// DNSDefault uses the resolver provided by the LXC bridge.
+	DNSDefault DNSMode = ""
+
+	// DNSCustom uses the given nameservers, and no others.
+	DNSCustom DNSMode = "custom"
+
+	// DNSOverTLS resolves through a stub resolver on the host, which
+	// forwards queries to the given nameservers over TLS. All DNS queries
+	// from the container are redirected to the stub.
+	DNSOverTLS DNSMode = "tls"
+
+	// DNSBlock blocks name resolution entirely.
+	DNSBlock DNSMode = "block"
+)
+
+// DNSPolicy describes a container's name resolution. Policies other than the
+// default install a firewall which filters the container's DNS traffic, and
+// leaves other traffic alone.
+type DNSPolicy struct {
+	Mode DNSMode
+
+	// Nameservers are IPv4 addresses of the nameservers to use. In TLS
+	// mode, a nameserver may be followed by the name in its certificate,
+	// such as 1.1.1.1#cloudflare-dns.com.
+	Nameservers []string
+}
+
+func DNS(policy DNSPolicy) Option {
+	return func(c *Container) error {
+		switch policy.Mode {
+		case DNSDefault, DNSBlock:
+			if len(policy.Nameservers) > 0 {
+				return errors.Errorf("nameservers are not supported in %q dns mode", policy.Mode)
+			}
+		case DNSCustom, DNSOverTLS:
+			if len(policy.Nameservers) == 0 {
+				return errors.Errorf("%q dns mode requires nameservers", policy.Mode)
+			}
+		default:
+			return errors.Errorf("unknown dns mode %q", policy.Mode)
+		}
+		for _, nameserver := range policy.Nameservers {
+			addr, tlsName := splitNameserver(nameserver)
+			ip := net.ParseIP(addr)
+			if ip == nil || ip.To4() == nil {
+				return errors.Errorf("invalid nameserver %q, expected an IPv4 address", nameserver)
+			}
+			if tlsName != "" && (policy.Mode != DNSOverTLS || !hostnamePattern.MatchString(tlsName)) {
+				return errors.Errorf("invalid nameserver %q", nameserver)
+			}
+		}
+		c.dns = policy
+		return nil
+	}
+}
+
+// splitNameserver splits a nameserver into its address and TLS name.
+func splitNameserver(nameserver string) (addr, tlsName string) {
+	fields := strings.SplitN(nameserver, "#", 2)
+	if len(fields) == 2 {
+		return fields[0], fields[1]
+	}
+	return fields[0], ""
+}
+
+func (p DNSPolicy) addrs() []string {
+	var addrs []string
+	for _, nameserver := range p.Nameservers {
+		addr, _ := splitNameserver(nameserver)
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// dnsEgressRules returns bridge rules which only let DNS queries from the
+// container reach the resolvers allowed by its policy. Queries to anything
+// else are dropped before any firewall allow rules are considered.
+func (c *Container) dnsEgressRules() []string {
+	const dns = "meta l4proto { tcp, udp } th dport { 53, 853 }"
+	switch c.dns.Mode {
+	case DNSCustom:
+		return []string{
+			fmt.Sprintf("ip daddr { %s } %s accept", strings.Join(c.dns.addrs(), ", "), dns),
+			dns + " drop",
+		}
+	case DNSOverTLS:
+		return []string{
+			"meta l4proto { tcp, udp } th dport 53 meta mark set " + c.firewallMark() + " accept",
+			dns + " drop",
+		}
+	case DNSBlock:
+		return []string{dns + " drop"}
+	}
+	return []string{
+		"ip daddr $bridge_addr meta l4proto { tcp, udp } th dport 53 accept",
+		dns + " drop",
+	}
+}
+
+// dnsStubPort returns the port of the container's DNS stub resolver on the
+// host, derived from the container name to keep stubs apart.
+func (c *Container) dnsStubPort() int {
+	h := sha1.Sum([]byte(c.Name()))
+	return 40000 + int(binary.BigEndian.Uint16(h[:2])%10000)
+}
+
+func (c *Container) dnsRedirectRules() []string {
+	if c.dns.Mode != DNSOverTLS {
+		return nil
+	}
+	return []string{fmt.Sprintf("meta l4proto { tcp, udp } th dport 53 redirect to :%d", c.dnsStubPort())}
+}
+
+// dnsUpScript runs an unbound stub resolver on the bridge the container is
+// attached to, which forwards queries over TLS.
+const dnsUpScript = `#!/bin/sh -e
+DNS_DIR=%[1]q
+BRIDGE=%[2]q
+BRIDGE_IP=$(ip -4 -o addr show dev $BRIDGE | awk '{print $4}' | cut -d/ -f1 | head -n 1)
+if [ -z "$BRIDGE_IP" ]; then
+    echo "cannot find address of $BRIDGE" >&2
+    exit 1
+fi
+
+mkdir -p -m 0700 $DNS_DIR
+cat >$DNS_DIR/unbound.conf <<UNBOUND
+server:
+    interface: $BRIDGE_IP@%[3]d
+    access-control: 0.0.0.0/0 allow
+    username: ""
+    chroot: ""
+    directory: "$DNS_DIR"
+    pidfile: "$DNS_DIR/unbound.pid"
+    logfile: "$DNS_DIR/unbound.log"
+    use-syslog: no
+    tls-cert-bundle: /etc/ssl/certs/ca-certificates.crt
+
+forward-zone:
+    name: "."
+    forward-tls-upstream: yes
+%[4]sUNBOUND
+unbound -c $DNS_DIR/unbound.conf
+`
+
+const dnsDownScript = `#!/bin/sh
+PID_FILE=%q/unbound.pid
+if [ -e "$PID_FILE" ]; then
+    kill $(cat $PID_FILE) 2>/dev/null || true
+    rm -f $PID_FILE
+fi
+`
+
+// setupDNS writes the container's resolv.conf according to its policy, and
+// in TLS mode, installs hooks which run its stub resolver.
+func (c *Container) setupDNS() error {
+	if c.dns.Mode == DNSDefault {
+		return nil
+	}
+	var resolvConf bytes.Buffer
+	fmt.Fprintf(&resolvConf, "# Generated by lxcify, dns mode %q\n", c.dns.Mode)
+	switch c.dns.Mode {
+	case DNSCustom:
+		for _, addr := range c.dns.addrs() {
+			fmt.Fprintf(&resolvConf, "nameserver %s\n", addr)
+		}
+	case DNSOverTLS:
+		// Queries are redirected to the stub wherever they are sent, but
+		// point at the bridge so that it is obvious where they go.
+		addr, err := bridgeAddr(c.network.link())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Fprintf(&resolvConf, "nameserver %s\n", addr)
+
+		var forwardAddrs string
+		for _, nameserver := range c.dns.Nameservers {
+			addr, tlsName := splitNameserver(nameserver)
+			forwardAddrs += fmt.Sprintf("    forward-addr: %s@853", addr)
+			if tlsName != "" {
+				forwardAddrs += "#" + tlsName
+			}
+			forwardAddrs += "\n"
+		}
+		dnsDir := path.Join(c.ConfigPath(), c.Name(), "dns")
+		err = c.installHook("pre-start", "dns-up.sh",
+			fmt.Sprintf(dnsUpScript, dnsDir, c.network.link(), c.dnsStubPort(), forwardAddrs))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = c.installHook("post-stop", "dns-down.sh", fmt.Sprintf(dnsDownScript, dnsDir))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(c.writeResolvConf(resolvConf.Bytes()))
+}
+
+// bridgeAddr returns the IPv4 address of a host bridge.
+func bridgeAddr(link string) (string, error) {
+	iface, err := net.InterfaceByName(link)
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot find bridge %q", link)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", errors.Errorf("bridge %q has no IPv4 address", link)
+}
+
+// writeResolvConf replaces the container's /etc/resolv.conf, which is usually
+// a symlink maintained by resolvconf, with a regular file owned by the
+// container root.
+func (c *Container) writeResolvConf(contents []byte) error {
+	f, err := ioutil.TempFile("", "lxcify-resolv")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(contents)
+	f.Close()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resolvConf := path.Join(c.ConfigPath(), c.Name(), "rootfs", "etc", "resolv.conf")
+	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf(
+		"sudo rm -f %s && sudo install -m 0644 -o %d -g %d %s %s",
+		resolvConf, startLxcId, startLxcId, f.Name(), resolvConf))
+	return errors.Annotate(cmd.Run(), "cannot write resolv.conf")
+}
@@ -0,0 +1,153 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// DownloadCacheDir returns the directory downloads are cached in, by SHA-256:
+// $XDG_CACHE_HOME/lxcify/downloads.
+func DownloadCacheDir() string {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		cacheHome = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(cacheHome, "lxcify", "downloads")
+}
+
+// downloadClient fetches downloads, giving up on those which take too long.
+var downloadClient = &http.Client{Timeout: 30 * time.Minute}
+
+// fetchDownload returns a host file with the download's contents, verified
+// against its SHA-256. Local files are used in place; others are fetched into
+// the cache, unless already there.
+func fetchDownload(d Download) (string, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if u.Scheme == "file" {
+		err = verifyFile(u.Path, d.SHA256)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return u.Path, nil
+	}
+
+	cached := filepath.Join(DownloadCacheDir(), d.SHA256)
+	if err := verifyFile(cached, d.SHA256); err == nil {
+		logger.Infof("using cached %s", d.URL)
+		return cached, nil
+	}
+	err = os.MkdirAll(DownloadCacheDir(), 0700)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	resp, err := downloadClient.Get(d.URL)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("cannot fetch %s: %s", d.URL, resp.Status)
+	}
+	f, err := ioutil.TempFile(DownloadCacheDir(), "partial-")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer os.Remove(f.Name())
+	sum, err := copySHA256(f, resp.Body)
+	f.Close()
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot fetch %s", d.URL)
+	}
+	if sum != d.SHA256 {
+		return "", errors.Errorf("sha256 mismatch: expected %s, got %s", d.SHA256, sum)
+	}
+	err = os.Rename(f.Name(), cached)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return cached, nil
+}
+
+// verifyFile checks a file's SHA-256.
+func verifyFile(filename, expected string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	sum, err := copySHA256(ioutil.Discard, f)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if sum != expected {
+		return errors.Errorf("sha256 mismatch: expected %s, got %s", expected, sum)
+	}
+	return nil
+}
+
+// copySHA256 copies src to dst, returning the hex SHA-256 of what was copied.
+func copySHA256(dst io.Writer, src io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// installDownloads fetches and verifies an app's downloads on the host, and
+// copies them into the container.
+func (c *Container) installDownloads(app *App) error {
+	for _, d := range app.Downloads {
+		filename, err := fetchDownload(d)
+		if err != nil {
+			return errors.Annotatef(err, "cannot download %s", d.URL)
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mode := d.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		err = c.pipeReader(f, "/bin/sh", "-c", fmt.Sprintf("mkdir -p '%s' && cat >'%s' && chmod %o '%s'",
+			path.Dir(d.Path), d.Path, mode, d.Path))
+		f.Close()
+		if err != nil {
+			return errors.Annotatef(err, "cannot copy %s to %s", d.URL, d.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	gc "launchpad.net/gocheck"
+)
+
+type DownloadsSuite struct {
+	cacheHome string
+}
+
+var _ = gc.Suite(&DownloadsSuite{})
+
+func (s *DownloadsSuite) SetUpTest(c *gc.C) {
+	s.cacheHome = os.Getenv("XDG_CACHE_HOME")
+	os.Setenv("XDG_CACHE_HOME", c.MkDir())
+}
+
+func (s *DownloadsSuite) TearDownTest(c *gc.C) {
+	os.Setenv("XDG_CACHE_HOME", s.cacheHome)
+}
+
+const downloadContents = "#!/bin/sh\necho installed\n"
+
+func downloadSHA256() string {
+	sum := sha256.Sum256([]byte(downloadContents))
+	return hex.EncodeToString(sum[:])
+}
+
+func (*DownloadsSuite) TestFetch(c *gc.C) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(downloadContents))
+	}))
+	defer server.Close()
+
+	d := Download{URL: server.URL + "/install.sh", SHA256: downloadSHA256(), Path: "/tmp/install.sh"}
+	filename, err := fetchDownload(d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(filename, gc.Equals, filepath.Join(DownloadCacheDir(), d.SHA256))
+	contents, err := ioutil.ReadFile(filename)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, downloadContents)
+	c.Assert(requests, gc.Equals, 1)
+
+	// The cached copy is used.
+	filename, err = fetchDownload(d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(filename, gc.Equals, filepath.Join(DownloadCacheDir(), d.SHA256))
+	c.Assert(requests, gc.Equals, 1)
+
+	// A corrupted cached copy is fetched again.
+	err = ioutil.WriteFile(filename, []byte("corrupt"), 0600)
+	c.Assert(err, gc.IsNil)
+	_, err = fetchDownload(d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(requests, gc.Equals, 2)
+}
+
+func (*DownloadsSuite) TestFetchMismatch(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered"))
+	}))
+	defer server.Close()
+
+	_, err := fetchDownload(Download{URL: server.URL, SHA256: downloadSHA256(), Path: "/tmp/install.sh"})
+	c.Assert(err, gc.ErrorMatches, "sha256 mismatch: expected "+downloadSHA256()+", got [0-9a-f]{64}")
+	files, err := ioutil.ReadDir(DownloadCacheDir())
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 0)
+}
+
+func (*DownloadsSuite) TestFetchNotFound(c *gc.C) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := fetchDownload(Download{URL: server.URL + "/missing", SHA256: downloadSHA256(), Path: "/tmp/x"})
+	c.Assert(err, gc.ErrorMatches, "cannot fetch .*/missing: 404 Not Found")
+}
+
+func (*DownloadsSuite) TestFetchFile(c *gc.C) {
+	local := filepath.Join(c.MkDir(), "install.sh")
+	err := ioutil.WriteFile(local, []byte(downloadContents), 0600)
+	c.Assert(err, gc.IsNil)
+
+	filename, err := fetchDownload(Download{URL: "file://" + local, SHA256: downloadSHA256(), Path: "/tmp/x"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(filename, gc.Equals, local)
+	_, err = os.Stat(DownloadCacheDir())
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	err = ioutil.WriteFile(local, []byte("tampered"), 0600)
+	c.Assert(err, gc.IsNil)
+	_, err = fetchDownload(Download{URL: "file://" + local, SHA256: downloadSHA256(), Path: "/tmp/x"})
+	c.Assert(err, gc.ErrorMatches, "sha256 mismatch: .*")
+}
+
+func (*DownloadsSuite) TestVerifyFile(c *gc.C) {
+	filename := filepath.Join(c.MkDir(), "install.sh")
+	err := verifyFile(filename, downloadSHA256())
+	c.Assert(os.IsNotExist(errors.Cause(err)), gc.Equals, true)
+	err = ioutil.WriteFile(filename, []byte(downloadContents), 0600)
+	c.Assert(err, gc.IsNil)
+	c.Assert(verifyFile(filename, downloadSHA256()), gc.IsNil)
+	c.Assert(verifyFile(filename, "00"), gc.ErrorMatches, "sha256 mismatch: expected 00, got "+downloadSHA256())
+}
@@ -0,0 +1,321 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// File is a host file or directory copied into the container when an app is
+// installed.
+type File struct {
+	// Host is the absolute path of the file or directory on the host.
+	Host string
+
+	// Container is the absolute path to copy it to in the container.
+	Container string
+
+	// Mode, if set, replaces the permissions of the file, or of each file
+	// in the directory. Otherwise the host permissions are kept.
+	Mode os.FileMode
+
+	// Owner, if set, is the user, or user:group, which owns the copy.
+	Owner string
+}
+
+var ownerPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*(:[a-z_][a-z0-9_-]*)?$|^[0-9]+(:[0-9]+)?$`)
+
+func (f File) check() error {
+	if !filepath.IsAbs(f.Host) {
+		return errors.Errorf("file %q: host path must be absolute", f.Host)
+	}
+	if !containerPathPattern.MatchString(f.Container) {
+		return errors.Errorf("file %q: invalid container path %q, expected an absolute path", f.Host, f.Container)
+	}
+	if f.Mode&^os.ModePerm != 0 {
+		return errors.Errorf("file %q: invalid mode %v", f.Host, f.Mode)
+	}
+	if f.Owner != "" && !ownerPattern.MatchString(f.Owner) {
+		return errors.Errorf("file %q: invalid owner %q", f.Host, f.Owner)
+	}
+	return nil
+}
+
+// installFiles copies an app's files into the container, and sets their
+// permissions and owner.
+func (c *Container) installFiles(app *App) error {
+	for _, f := range app.Files {
+		err := c.PushFile(f.Host, f.Container)
+		if err != nil {
+			return errors.Annotatef(err, "cannot copy %s to %s", f.Host, f.Container)
+		}
+		var script []string
+		if f.Mode != 0 {
+			script = append(script, fmt.Sprintf("find '%s' -type f -exec chmod %o {} +", f.Container, f.Mode))
+		}
+		if f.Owner != "" {
+			script = append(script, fmt.Sprintf("chown -R '%s' '%s'", f.Owner, f.Container))
+		}
+		if len(script) == 0 {
+			continue
+		}
+		err = c.RunCommand(os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(),
+			"/bin/sh", "-ec", strings.Join(script, "\n"))
+		if err != nil {
+			return errors.Annotatef(err, "cannot set permissions of %s", f.Container)
+		}
+	}
+	return nil
+}
+
+// PushFile copies a host file or directory to an absolute path in the running
+// container, replacing any file or directory there. Permissions are kept, and
+// the copy is owned by root.
+func (c *Container) PushFile(hostPath, containerPath string) error {
+	if !path.IsAbs(containerPath) {
+		return errors.Errorf("container path %q must be absolute", containerPath)
+	}
+	if path.Clean(containerPath) == "/" {
+		return errors.New("cannot replace the container's root directory")
+	}
+	fi, err := os.Stat(hostPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !fi.IsDir() {
+		f, err := os.Open(hostPath)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer f.Close()
+		return errors.Trace(c.pipeReader(f, "/bin/sh", "-c", fmt.Sprintf(
+			`rm -rf "$1" && mkdir -p "$(dirname "$1")" && cat >"$1" && chmod %o "$1"`, fi.Mode().Perm()),
+			"push", containerPath))
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeTar(w, hostPath))
+	}()
+	defer r.Close()
+	return errors.Trace(c.pipeReader(r, "/bin/sh", "-c",
+		`rm -rf "$1" && mkdir -p "$1" && tar -x --no-same-owner -C "$1"`, "push", containerPath))
+}
+
+// PullFile copies a file or directory at an absolute path in the running
+// container to the host, replacing any file there. A directory's contents are
+// extracted into any directory already there.
+func (c *Container) PullFile(containerPath, hostPath string) error {
+	if !path.IsAbs(containerPath) {
+		return errors.Errorf("container path %q must be absolute", containerPath)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devNull.Close()
+	isDir := c.RunCommand(devNull.Fd(), devNull.Fd(), os.Stderr.Fd(), "test", "-d", containerPath) == nil
+	if isDir {
+		return errors.Trace(c.readCommand(func(r io.Reader) error {
+			return readTar(r, hostPath)
+		}, "tar", "-c", "-C", containerPath, "."))
+	}
+	err = os.MkdirAll(filepath.Dir(hostPath), 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.Create(hostPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = c.readCommand(func(r io.Reader) error {
+		_, err := io.Copy(f, r)
+		return errors.Trace(err)
+	}, "cat", containerPath)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Trace(err)
+}
+
+// readCommand runs a command in the running container, passing its standard
+// output to read.
+func (c *Container) readCommand(read func(io.Reader) error, args ...string) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+	done := make(chan error, 1)
+	go func() {
+		err := read(r)
+		// Drain the pipe, so that the command isn't blocked writing to it.
+		io.Copy(ioutil.Discard, r)
+		done <- err
+	}()
+	err = c.RunCommand(os.Stdin.Fd(), w.Fd(), os.Stderr.Fd(), args...)
+	w.Close()
+	readErr := <-done
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(readErr)
+}
+
+// writeTar writes the contents of a host directory as a tar stream, with
+// names relative to it. Files other than directories, regular files and
+// symlinks are skipped.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Trace(err)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return errors.Trace(err)
+		}
+		var link string
+		switch {
+		case fi.Mode().IsDir(), fi.Mode().IsRegular():
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err = os.Readlink(p)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		default:
+			logger.Warningf("skipping %s: not a regular file, directory or symlink", p)
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "root", "root"
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(tw.Close())
+}
+
+// readTar extracts a tar stream into a host directory. Entries which would
+// be written outside of it, and those other than directories, regular files
+// and symlinks, are skipped.
+func readTar(r io.Reader, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+		name := path.Clean(hdr.Name)
+		if name == "." {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || throughSymlink(dir, name) {
+			logger.Warningf("skipping %q: outside of %s", hdr.Name, dir)
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			// Replace symlinks rather than write through them.
+			os.Remove(target)
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+		default:
+			logger.Warningf("skipping %q: not a regular file, directory or symlink", hdr.Name)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// throughSymlink returns whether a path relative to dir passes through a
+// symlink, which may lead outside of it.
+func throughSymlink(dir, name string) bool {
+	p := dir
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return false
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFile(filename string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Trace(err)
+}
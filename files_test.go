@@ -0,0 +1,171 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type FilesSuite struct{}
+
+var _ = gc.Suite(&FilesSuite{})
+
+func (*FilesSuite) TestTarRoundTrip(c *gc.C) {
+	src := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(src, "sub", "empty"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "sub", "file"), []byte("contents"), 0640), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "run"), []byte("#!/bin/sh\n"), 0755), gc.IsNil)
+	c.Assert(os.Symlink("sub/file", filepath.Join(src, "link")), gc.IsNil)
+
+	var buf bytes.Buffer
+	err := writeTar(&buf, src)
+	c.Assert(err, gc.IsNil)
+
+	// Names are relative, and entries are owned by root.
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		names = append(names, hdr.Name)
+		c.Assert(hdr.Uid, gc.Equals, 0)
+		c.Assert(hdr.Uname, gc.Equals, "root")
+	}
+	c.Assert(names, gc.DeepEquals, []string{"link", "run", "sub", "sub/empty", "sub/file"})
+
+	dst := filepath.Join(c.MkDir(), "copy")
+	err = readTar(&buf, dst)
+	c.Assert(err, gc.IsNil)
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "sub", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "contents")
+	fi, err := os.Stat(filepath.Join(dst, "sub", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(fi.Mode().Perm(), gc.Equals, os.FileMode(0640))
+	fi, err = os.Stat(filepath.Join(dst, "run"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(fi.Mode().Perm(), gc.Equals, os.FileMode(0755))
+	fi, err = os.Stat(filepath.Join(dst, "sub", "empty"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(fi.IsDir(), gc.Equals, true)
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(link, gc.Equals, "sub/file")
+}
+
+func (*FilesSuite) TestWriteTarMissing(c *gc.C) {
+	err := writeTar(ioutil.Discard, filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, gc.NotNil)
+}
+
+// tarEntry is a tar stream entry, which is a regular file unless it has a
+// link.
+type tarEntry struct {
+	name, link, contents string
+}
+
+func tarStream(c *gc.C, entries ...tarEntry) io.Reader {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.contents))}
+		if e.link != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Mode = tar.TypeSymlink, e.link, 0777
+		}
+		c.Assert(tw.WriteHeader(hdr), gc.IsNil)
+		_, err := tw.Write([]byte(e.contents))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+	return &buf
+}
+
+func (*FilesSuite) TestReadTarOutside(c *gc.C) {
+	root := c.MkDir()
+	dst := filepath.Join(root, "dst")
+	outside := filepath.Join(root, "outside")
+	c.Assert(os.Mkdir(outside, 0755), gc.IsNil)
+
+	err := readTar(tarStream(c,
+		tarEntry{name: "../escaped", contents: "x"},
+		tarEntry{name: "sub/../../escaped", contents: "x"},
+		tarEntry{name: filepath.Join(outside, "absolute"), contents: "x"},
+		tarEntry{name: "link", link: outside},
+		tarEntry{name: "link/through", contents: "x"},
+		tarEntry{name: "inside", contents: "ok"},
+	), dst)
+	c.Assert(err, gc.IsNil)
+
+	files, err := ioutil.ReadDir(outside)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 0)
+	_, err = os.Stat(filepath.Join(root, "escaped"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "inside"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "ok")
+}
+
+func (*FilesSuite) TestReadTarReplacesSymlinks(c *gc.C) {
+	root := c.MkDir()
+	dst := filepath.Join(root, "dst")
+	target := filepath.Join(root, "target")
+	c.Assert(ioutil.WriteFile(target, []byte("original"), 0644), gc.IsNil)
+	c.Assert(os.Mkdir(dst, 0755), gc.IsNil)
+	c.Assert(os.Symlink(target, filepath.Join(dst, "file")), gc.IsNil)
+
+	err := readTar(tarStream(c, tarEntry{name: "file", contents: "replaced"}), dst)
+	c.Assert(err, gc.IsNil)
+
+	contents, err := ioutil.ReadFile(target)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "original")
+	fi, err := os.Lstat(filepath.Join(dst, "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(fi.Mode().IsRegular(), gc.Equals, true)
+	contents, err = ioutil.ReadFile(filepath.Join(dst, "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "replaced")
+}
+
+func (*FilesSuite) TestSourceReader(c *gc.C) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("partial"))
+		w.CloseWithError(io.ErrUnexpectedEOF)
+	}()
+	src := &sourceReader{Reader: r}
+	var buf bytes.Buffer
+	io.Copy(&buf, src)
+	c.Assert(buf.String(), gc.Equals, "partial")
+	c.Assert(src.err, gc.Equals, io.ErrUnexpectedEOF)
+
+	src = &sourceReader{Reader: bytes.NewReader([]byte("complete"))}
+	io.Copy(ioutil.Discard, src)
+	c.Assert(src.err, gc.IsNil)
+}
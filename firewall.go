@@ -0,0 +1,368 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/juju/errors"
+)
+
+// FirewallRule allows traffic from a container to a destination.
+type FirewallRule struct {
+	// Destination is a host name, an IPv4 address or an IPv4 CIDR. Host
+	// names are resolved when the container starts.
+	Destination string
+
+	// Ports restricts the rule to these TCP and UDP destination ports. All
+	// ports are allowed if empty.
+	Ports []int
+}
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+func (r FirewallRule) validate() error {
+	if ip := net.ParseIP(r.Destination); ip != nil {
+		if ip.To4() == nil {
+			return errors.Errorf("firewall destination %q: IPv6 is not supported", r.Destination)
+		}
+	} else if ip, _, err := net.ParseCIDR(r.Destination); err == nil {
+		if ip.To4() == nil {
+			return errors.Errorf("firewall destination %q: IPv6 is not supported", r.Destination)
+		}
+	} else if !hostnamePattern.MatchString(r.Destination) {
+		return errors.Errorf("invalid firewall destination %q", r.Destination)
+	}
+	for _, port := range r.Ports {
+		if port < 1 || port > 65535 {
+			return errors.Errorf("invalid firewall port %d for %q", port, r.Destination)
+		}
+	}
+	return nil
+}
+
+// match renders the rule as an nftables expression matching its traffic.
+func (r FirewallRule) match() string {
+	rule := fmt.Sprintf("ip daddr { %s }", r.Destination)
+	if len(r.Ports) > 0 {
+		var ports []string
+		for _, port := range r.Ports {
+			ports = append(ports, fmt.Sprint(port))
+		}
+		rule += fmt.Sprintf(" meta l4proto { tcp, udp } th dport { %s }", strings.Join(ports, ", "))
+	}
+	return rule
+}
+
+// Firewall restricts the container's egress traffic to the given
+// destinations, dropping all other traffic. Without rules, the container may
+// only reach the bridge's DHCP and DNS services. Rules are installed with
+// nftables on the host when the container starts and removed when it stops,
+// which requires the user to be able to run nft with sudo without a password,
+// and Linux 5.3 or later for connection tracking on the bridge.
+//
+// Traffic is matched by the host side of the container's veth pair, which
+// lxcify names. Frames on it from any MAC address other than the one lxcify
+// assigns are dropped, so the rules cannot be evaded by changing it.
+//
+// In Tor network mode, the rules restrict which destinations may be reached
+// through Tor.
+func Firewall(rules ...FirewallRule) Option {
+	return func(c *Container) error {
+		for _, rule := range rules {
+			err := rule.validate()
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		c.firewallSet = true
+		c.firewall = append(c.firewall, rules...)
+		return nil
+	}
+}
+
+func (c *Container) firewallEnabled() bool {
+	return c.egressRestricted() || c.dns.Mode != DNSDefault
+}
+
+// egressRestricted returns whether the container's egress traffic is dropped
+// unless allowed, by a firewall, a proxy, Tor or the VPN kill switch. DNS
+// policies alone only filter DNS traffic.
+func (c *Container) egressRestricted() bool {
+	switch c.network.Mode {
+	case NetworkProxy, NetworkTor:
+		return true
+	}
+	return c.firewallSet || len(c.vpnEndpoints) > 0
+}
+
+// hwaddr returns the MAC address lxcify assigns to the container's network
+// interface, the only one its firewall accepts.
+func (c *Container) hwaddr() string {
+	h := sha1.Sum([]byte(c.Name()))
+	return fmt.Sprintf("00:16:3e:%02x:%02x:%02x", h[0], h[1], h[2])
+}
+
+// vethName returns the name lxcify gives the host side of the container's
+// veth pair, on which firewall rules match its traffic. Interface names are
+// limited to 15 characters.
+func (c *Container) vethName() string {
+	h := sha1.Sum([]byte(c.Name()))
+	return fmt.Sprintf("lxcify%x", h[:4])
+}
+
+// firewallMark returns the packet mark which selects the container's traffic
+// for redirection to services on the host.
+func (c *Container) firewallMark() string {
+	h := sha1.Sum([]byte(c.Name()))
+	return fmt.Sprintf("0x%x", binary.BigEndian.Uint32(h[:4])|0x1)
+}
+
+func (c *Container) firewallTable() string {
+	return "lxcify-" + c.Name()
+}
+
+func (c *Container) firewallFile() string {
+	return path.Join(c.ConfigPath(), c.Name(), "firewall.nft")
+}
+
+// egressRules returns the nftables rules, in order, which accept or drop
+// traffic leaving the container. Anything else is dropped if egress is
+// restricted, and accepted otherwise.
+func (c *Container) egressRules() []string {
+	rules := []string{
+		"ether type arp accept",
+		"ether type ip udp dport { 67, 68 } accept",
+	}
+	if c.network.Mode == NetworkTor {
+		return append(rules, c.torEgressRules()...)
+	}
+	if c.vpn != nil && len(c.vpnEndpoints) > 0 {
+		// Name resolution goes through the tunnel.
+		rules = append(rules, c.vpnEgressRules()...)
+	} else {
+		rules = append(rules, c.dnsEgressRules()...)
+	}
+	if c.network.Mode == NetworkProxy {
+		// The proxy was validated by the Network option.
+		proxy, _ := c.network.proxyRule()
+		rules = append(rules, proxy.match()+" accept")
+	}
+	for _, rule := range c.firewall {
+		rules = append(rules, rule.match()+" accept")
+	}
+	return rules
+}
+
+// redirectRules returns the nftables rules which redirect marked traffic to
+// services on the host.
+func (c *Container) redirectRules() []string {
+	if c.network.Mode == NetworkTor {
+		return c.torRedirectRules()
+	}
+	return c.dnsRedirectRules()
+}
+
+// Traffic from a bridged container arrives on its veth, and reaches the host
+// in the bridge input hook, and other containers on the bridge in the forward
+// hook. Frames with a source address other than the container's are dropped. Isolated containers
+// may only reach the host. Replies to connections made to the container, such
+// as forwarded ports, are accepted. Traffic marked by the egress rules is
+// redirected to services on the host, and dropped if it would be routed
+// anywhere else. Declaring and deleting tables first makes loading the
+// ruleset idempotent.
+//
+// $container_mac and $bridge_addr are defined by the hook which loads the
+// ruleset.
+const firewallTemplate = `# Generated by lxcify for container {{.Name}}.
+table bridge {{.Table}}
+delete table bridge {{.Table}}
+
+table bridge {{.Table}} {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		iifname "{{.Veth}}" ether saddr != $container_mac drop
+		iifname "{{.Veth}}" jump egress
+	}
+
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		iifname "{{.Veth}}" ether saddr != $container_mac drop
+{{if .Isolate}}		iifname "{{.Veth}}" ether type arp accept
+		iifname "{{.Veth}}" drop
+{{else}}		iifname "{{.Veth}}" jump egress
+{{end}}	}
+
+	chain egress {
+		ct state established,related accept
+{{range .Rules}}		{{.}}
+{{end}}		{{if .Restrict}}drop{{else}}accept{{end}}
+	}
+}
+{{if .Redirects}}
+table ip {{.Table}}
+delete table ip {{.Table}}
+
+table ip {{.Table}} {
+	chain prerouting {
+		type nat hook prerouting priority -100; policy accept;
+{{range .Redirects}}		meta mark {{$.Mark}} {{.}}
+{{end}}	}
+
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		meta mark {{.Mark}} drop
+	}
+}
+{{end}}`
+
+func (c *Container) firewallRuleset() (string, error) {
+	t, err := template.New("firewall").Parse(firewallTemplate)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, map[string]interface{}{
+		"Name":      c.Name(),
+		"Table":     c.firewallTable(),
+		"Veth":      c.vethName(),
+		"Rules":     c.egressRules(),
+		"Restrict":  c.egressRestricted(),
+		"Isolate":   c.network.Mode == NetworkTor,
+		"Mark":      c.firewallMark(),
+		"Redirects": c.redirectRules(),
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return buf.String(), nil
+}
+
+// firewallUpScript loads the ruleset, matching the MAC address the launcher
+// assigns if it randomizes the container's identity. Failing to load it
+// prevents the container from starting.
+const firewallUpScript = `#!/bin/sh -e
+BRIDGE=%[1]q
+BRIDGE_IP=$(ip -4 -o addr show dev $BRIDGE | awk '{print $4}' | cut -d/ -f1 | head -n 1)
+if [ -z "$BRIDGE_IP" ]; then
+    echo "cannot find address of $BRIDGE" >&2
+    exit 1
+fi
+{
+    echo "define container_mac = ${LXCIFY_HWADDR:-%[2]s}"
+    echo "define bridge_addr = $BRIDGE_IP"
+    cat %[3]q
+} | sudo -n nft -f -
+`
+
+const firewallDownScript = `#!/bin/sh
+sudo -n nft delete table bridge %[1]q 2>/dev/null || true
+sudo -n nft delete table ip %[1]q 2>/dev/null || true
+`
+
+// writeFirewall writes the container's nftables ruleset.
+func (c *Container) writeFirewall() error {
+	ruleset, err := c.firewallRuleset()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(c.firewallFile(), []byte(ruleset), 0600))
+}
+
+// setupFirewall writes the container's nftables ruleset, and hooks which
+// load it when the container starts and remove it when the container stops.
+func (c *Container) setupFirewall() error {
+	err := c.writeFirewall()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = c.setLxcConfig([]lxcConfigItem{
+		{"lxc.network.hwaddr", c.hwaddr()},
+		{"lxc.network.veth.pair", c.vethName()},
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = c.installHook("pre-start", "firewall-up.sh",
+		fmt.Sprintf(firewallUpScript, c.network.link(), c.hwaddr(), c.firewallFile()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.installHook("post-stop", "firewall-down.sh",
+		fmt.Sprintf(firewallDownScript, c.firewallTable())))
+}
+
+// FirewallRules returns the container's firewall rules, as currently loaded
+// if the container is running, or otherwise as they will be loaded when it
+// starts. An error satisfying errors.IsNotFound is returned if the container
+// has no firewall.
+func (c *Container) FirewallRules() (rules string, active bool, err error) {
+	if c.Running() {
+		out, err := exec.Command("sudo", "-n", "nft", "list", "table", "bridge", c.firewallTable()).Output()
+		if err == nil {
+			// Tor redirection rules, if any, are in a table of the same name.
+			natOut, err := exec.Command("sudo", "-n", "nft", "list", "table", "ip", c.firewallTable()).Output()
+			if err == nil {
+				out = append(out, natOut...)
+			}
+			return string(out), true, nil
+		}
+		logger.Debugf("cannot list active firewall rules: %v", err)
+	}
+	contents, err := ioutil.ReadFile(c.firewallFile())
+	if os.IsNotExist(err) {
+		return "", false, errors.NotFoundf("firewall for %q", c.Name())
+	} else if err != nil {
+		return "", false, errors.Trace(err)
+	}
+	return string(contents), false, nil
+}
+
+// verifyFirewall checks that a started container's network interface has the
+// MAC address its firewall accepts, stopping the container if not, rather than
+// leaving it running without a network.
+func (c *Container) verifyFirewall(expected string) error {
+	var hwaddr string
+	err := c.readCommand(func(r io.Reader) error {
+		out, err := ioutil.ReadAll(r)
+		hwaddr = strings.TrimSpace(string(out))
+		return errors.Trace(err)
+	}, "cat", "/sys/class/net/eth0/address")
+	if err == nil && strings.EqualFold(hwaddr, expected) {
+		return nil
+	}
+	if stopErr := c.Stop(); stopErr != nil {
+		logger.Errorf("cannot stop container %q: %v", c.Name(), stopErr)
+	}
+	if err != nil {
+		return errors.Annotate(err, "cannot verify firewall")
+	}
+	return errors.Errorf("container has MAC address %q, but its firewall accepts %q", hwaddr, expected)
+}
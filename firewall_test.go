@@ -0,0 +1,206 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"strconv"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+type FirewallSuite struct{}
+
+var _ = gc.Suite(&FirewallSuite{})
+
+// chain returns the rules of the named chain in a rendered ruleset.
+func chain(c *gc.C, ruleset, name string) []string {
+	start := strings.Index(ruleset, "\tchain "+name+" {\n")
+	c.Assert(start, gc.Not(gc.Equals), -1)
+	body := ruleset[start+len("\tchain "+name+" {\n"):]
+	body = body[:strings.Index(body, "\t}\n")]
+	var rules []string
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			rules = append(rules, line)
+		}
+	}
+	return rules
+}
+
+func (*FirewallSuite) TestEnabled(c *gc.C) {
+	c.Assert(newTestContainer(c).firewallEnabled(), gc.Equals, false)
+	c.Assert(newTestContainer(c, Firewall()).firewallEnabled(), gc.Equals, true)
+	c.Assert(newTestContainer(c, Network(NetworkPolicy{Mode: NetworkTor})).firewallEnabled(), gc.Equals, true)
+}
+
+func (*FirewallSuite) TestEmptyRuleset(c *gc.C) {
+	container := newTestContainer(c, Firewall())
+	ruleset, err := container.firewallRuleset()
+	c.Assert(err, gc.IsNil)
+	veth := `iifname "` + container.vethName() + `"`
+	c.Assert(chain(c, ruleset, "input"), gc.DeepEquals, []string{
+		"type filter hook input priority 0; policy accept;",
+		veth + " ether saddr != $container_mac drop",
+		veth + " jump egress",
+	})
+	c.Assert(chain(c, ruleset, "forward"), gc.DeepEquals, []string{
+		"type filter hook forward priority 0; policy accept;",
+		veth + " ether saddr != $container_mac drop",
+		veth + " jump egress",
+	})
+	c.Assert(chain(c, ruleset, "egress"), gc.DeepEquals, []string{
+		"ct state established,related accept",
+		"ether type arp accept",
+		"ether type ip udp dport { 67, 68 } accept",
+		"ip daddr $bridge_addr meta l4proto { tcp, udp } th dport 53 accept",
+		"meta l4proto { tcp, udp } th dport { 53, 853 } drop",
+		"drop",
+	})
+	c.Assert(ruleset, gc.Not(gc.Matches), `(?s).*table ip .*`)
+}
+
+func (*FirewallSuite) TestAllowRuleset(c *gc.C) {
+	container := newTestContainer(c, Firewall(
+		FirewallRule{Destination: "intranet.example.com", Ports: []int{80, 443}},
+		FirewallRule{Destination: "10.0.0.0/8"},
+	))
+	ruleset, err := container.firewallRuleset()
+	c.Assert(err, gc.IsNil)
+	egress := chain(c, ruleset, "egress")
+	c.Assert(egress[len(egress)-3:], gc.DeepEquals, []string{
+		"ip daddr { intranet.example.com } meta l4proto { tcp, udp } th dport { 80, 443 } accept",
+		"ip daddr { 10.0.0.0/8 } accept",
+		"drop",
+	})
+}
+
+func (*FirewallSuite) TestTorRuleset(c *gc.C) {
+	container := newTestContainer(c, Network(NetworkPolicy{Mode: NetworkTor}))
+	ruleset, err := container.firewallRuleset()
+	c.Assert(err, gc.IsNil)
+	mark := container.firewallMark()
+	veth := `iifname "` + container.vethName() + `"`
+	c.Assert(chain(c, ruleset, "forward"), gc.DeepEquals, []string{
+		"type filter hook forward priority 0; policy accept;",
+		veth + " ether saddr != $container_mac drop",
+		veth + " ether type arp accept",
+		veth + " drop",
+	})
+	c.Assert(chain(c, ruleset, "egress"), gc.DeepEquals, []string{
+		"ct state established,related accept",
+		"ether type arp accept",
+		"ether type ip udp dport { 67, 68 } accept",
+		"ether type ip udp dport 53 meta mark set " + mark + " accept",
+		"ether type ip meta l4proto tcp meta mark set " + mark + " accept",
+		"drop",
+	})
+	transPort, dnsPort := container.torPorts()
+	c.Assert(chain(c, ruleset, "prerouting"), gc.DeepEquals, []string{
+		"type nat hook prerouting priority -100; policy accept;",
+		"meta mark " + mark + " udp dport 53 redirect to :" + strconv.Itoa(dnsPort),
+		"meta mark " + mark + " meta l4proto tcp redirect to :" + strconv.Itoa(transPort),
+	})
+}
+
+func (*FirewallSuite) TestHwaddr(c *gc.C) {
+	container := newTestContainer(c)
+	c.Assert(container.hwaddr(), gc.Matches, `00:16:3e(:[0-9a-f]{2}){3}`)
+	c.Assert(container.hwaddr(), gc.Equals, newTestContainer(c).hwaddr())
+}
+
+func (*FirewallSuite) TestVethName(c *gc.C) {
+	container := newTestContainer(c)
+	c.Assert(container.vethName(), gc.Matches, `lxcify[0-9a-f]{8}`)
+	c.Assert(len(container.vethName()) <= 15, gc.Equals, true)
+}
+
+// TestForeignMAC checks that frames from the container's veth with a source
+// address other than the one lxcify assigned are dropped before any rule can
+// accept them.
+func (*FirewallSuite) TestForeignMAC(c *gc.C) {
+	for i, options := range [][]Option{
+		{Firewall(FirewallRule{Destination: "10.0.0.0/8"})},
+		{DNS(DNSPolicy{Mode: DNSBlock})},
+		{Network(NetworkPolicy{Mode: NetworkTor})},
+	} {
+		c.Log("test#", i)
+		container := newTestContainer(c, options...)
+		ruleset, err := container.firewallRuleset()
+		c.Assert(err, gc.IsNil)
+		for _, name := range []string{"input", "forward"} {
+			rules := chain(c, ruleset, name)
+			c.Assert(rules[1], gc.Equals,
+				`iifname "`+container.vethName()+`" ether saddr != $container_mac drop`)
+			for _, rule := range rules[2:] {
+				c.Assert(rule, gc.Matches, `iifname "`+container.vethName()+`" .*`)
+			}
+		}
+	}
+}
+
+func (*FirewallSuite) TestIdentityHwaddr(c *gc.C) {
+	container := newTestContainer(c, Identity(EphemeralIdentity), Firewall())
+	c.Assert(container.randomizeIdentityScript(), gc.Matches,
+		`(?s).*START_OPTS="-s lxc.utsname=\$HOST_NAME -s lxc.network.hwaddr=\$HWADDR"\nexport LXCIFY_HWADDR=\$HWADDR\n`)
+}
+
+func (*FirewallSuite) TestDNSRuleset(c *gc.C) {
+	testCases := []struct {
+		options []Option
+		egress  []string
+	}{{
+		options: []Option{DNS(DNSPolicy{Mode: DNSBlock})},
+		egress: []string{
+			"meta l4proto { tcp, udp } th dport { 53, 853 } drop",
+			"accept",
+		},
+	}, {
+		options: []Option{DNS(DNSPolicy{Mode: DNSCustom, Nameservers: []string{"9.9.9.9"}})},
+		egress: []string{
+			"ip daddr { 9.9.9.9 } meta l4proto { tcp, udp } th dport { 53, 853 } accept",
+			"meta l4proto { tcp, udp } th dport { 53, 853 } drop",
+			"accept",
+		},
+	}, {
+		options: []Option{
+			DNS(DNSPolicy{Mode: DNSCustom, Nameservers: []string{"9.9.9.9"}}),
+			Firewall(FirewallRule{Destination: "192.0.2.1"}),
+		},
+		egress: []string{
+			"ip daddr { 9.9.9.9 } meta l4proto { tcp, udp } th dport { 53, 853 } accept",
+			"meta l4proto { tcp, udp } th dport { 53, 853 } drop",
+			"ip daddr { 192.0.2.1 } accept",
+			"drop",
+		},
+	}}
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		container := newTestContainer(c, testCase.options...)
+		c.Assert(container.firewallEnabled(), gc.Equals, true)
+		ruleset, err := container.firewallRuleset()
+		c.Assert(err, gc.IsNil)
+		egress := chain(c, ruleset, "egress")
+		c.Assert(egress[:3], gc.DeepEquals, []string{
+			"ct state established,related accept",
+			"ether type arp accept",
+			"ether type ip udp dport { 67, 68 } accept",
+		})
+		c.Assert(egress[3:], gc.DeepEquals, testCase.egress)
+	}
+}
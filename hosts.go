@@ -0,0 +1,82 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+const (
+	hostsBegin = "# lxcify hosts"
+	hostsEnd   = "# end lxcify hosts"
+)
+
+// SetHosts replaces the host name entries lxcify manages in the running
+// container's /etc/hosts, such as the addresses of linked containers.
+func (c *Container) SetHosts(hosts map[string]string) error {
+	var names []string
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var block bytes.Buffer
+	fmt.Fprintln(&block, hostsBegin)
+	for _, name := range names {
+		fmt.Fprintf(&block, "%s %s\n", hosts[name], name)
+	}
+	fmt.Fprintln(&block, hostsEnd)
+
+	if c.identity == EphemeralIdentity {
+		// /etc/hosts is bind mounted read-only from the identity directory,
+		// so update it on the host, in place.
+		hostsFile := path.Join(c.identityDir(), "hosts")
+		contents, err := ioutil.ReadFile(hostsFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		contents = append(stripHosts(contents), block.Bytes()...)
+		return errors.Trace(ioutil.WriteFile(hostsFile, contents, 0644))
+	}
+	return errors.Annotate(c.pipeCommand(block.Bytes(), "/bin/sh", "-c", fmt.Sprintf(
+		"sed -i '/^%s$/,/^%s$/d' /etc/hosts && cat >>/etc/hosts", hostsBegin, hostsEnd)),
+		"cannot update /etc/hosts")
+}
+
+// stripHosts removes the lxcify managed block from a hosts file.
+func stripHosts(contents []byte) []byte {
+	var out bytes.Buffer
+	inBlock := false
+	for _, line := range bytes.SplitAfter(contents, []byte("\n")) {
+		trimmed := string(bytes.TrimRight(line, "\n"))
+		switch {
+		case trimmed == hostsBegin:
+			inBlock = true
+		case trimmed == hostsEnd:
+			inBlock = false
+		case !inBlock:
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,147 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// IdentityMode selects whether a container's network and host identifiers
+// persist across starts.
+type IdentityMode string
+
+const (
+	// PersistentIdentity keeps the container's hostname, MAC address and
+	// machine-id.
+	PersistentIdentity IdentityMode = ""
+
+	// EphemeralIdentity randomizes the container's hostname, MAC address and
+	// machine-id each time the launcher starts it.
+	EphemeralIdentity IdentityMode = "ephemeral"
+)
+
+func Identity(mode IdentityMode) Option {
+	return func(c *Container) error {
+		switch mode {
+		case PersistentIdentity, EphemeralIdentity:
+		default:
+			return errors.Errorf("unknown identity %q", mode)
+		}
+		c.identity = mode
+		return nil
+	}
+}
+
+func (c *Container) identityDir() string {
+	return path.Join(c.ConfigPath(), c.Name(), "identity")
+}
+
+// identityMounts returns mounts which replace the container's identity files
+// with ones the launcher can rewrite before each start.
+func (c *Container) identityMounts() []Mount {
+	var mounts []Mount
+	for _, name := range []string{"machine-id", "hostname", "hosts"} {
+		mounts = append(mounts, Mount{
+			Host:      path.Join(c.identityDir(), name),
+			Container: path.Join("etc", name),
+			ReadOnly:  true,
+		})
+	}
+	return mounts
+}
+
+const identityHosts = `127.0.0.1 localhost
+127.0.1.1 %s
+::1 localhost ip6-localhost ip6-loopback
+`
+
+// setupIdentity writes an initial random identity, which the launcher
+// replaces on each start.
+func (c *Container) setupIdentity() error {
+	err := os.MkdirAll(c.identityDir(), 0700)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	machineID := make([]byte, 16)
+	_, err = rand.Read(machineID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	hostname := "host-" + hex.EncodeToString(machineID[:3])
+	files := map[string]string{
+		"machine-id": hex.EncodeToString(machineID) + "\n",
+		"hostname":   hostname + "\n",
+		"hosts":      fmt.Sprintf(identityHosts, hostname),
+	}
+	for name, contents := range files {
+		err = ioutil.WriteFile(path.Join(c.identityDir(), name), []byte(contents), 0644)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// randomizeIdentityScript is included in the launcher to replace the
+// container's identity before it is started. Host name entries set with
+// SetHosts are kept. The values used are logged so they can be audited.
+const randomizeIdentityScript = `IDENTITY_DIR=%[1]q
+rand_hex() {
+    od -An -N$1 -tx1 /dev/urandom | tr -d ' \n'
+}
+MACHINE_ID=$(rand_hex 16)
+HOST_NAME=host-$(rand_hex 3)
+HWADDR=00:16:3e:$(od -An -N3 -tx1 /dev/urandom | sed 's/^ *//; s/ /:/g')
+echo $MACHINE_ID >$IDENTITY_DIR/machine-id
+echo $HOST_NAME >$IDENTITY_DIR/hostname
+HOSTS_BLOCK=$(sed -n '/^%[4]s$/,/^%[5]s$/p' $IDENTITY_DIR/hosts 2>/dev/null || true)
+printf '%[2]s' $HOST_NAME >$IDENTITY_DIR/hosts
+if [ -n "$HOSTS_BLOCK" ]; then
+    echo "$HOSTS_BLOCK" >>$IDENTITY_DIR/hosts
+fi
+echo "$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ) hostname=$HOST_NAME hwaddr=$HWADDR machine-id=$MACHINE_ID" \
+    | tee -a $IDENTITY_DIR/identity.log >&2
+START_OPTS="-s lxc.utsname=$HOST_NAME%[3]s"
+`
+
+func (c *Container) randomizeIdentityScript() string {
+	if c.identity != EphemeralIdentity {
+		return ""
+	}
+	hostsFormat := strings.Replace(identityHosts, "\n", `\n`, -1)
+	var hwaddrOpt string
+	if c.network.Mode != NetworkNone {
+		hwaddrOpt = " -s lxc.network.hwaddr=$HWADDR"
+	}
+	script := fmt.Sprintf(randomizeIdentityScript, c.identityDir(), hostsFormat, hwaddrOpt,
+		hostsBegin, hostsEnd)
+	if c.network.Mode != NetworkNone {
+		// Hooks which find the container by its MAC address read it from
+		// the environment.
+		script += "export LXCIFY_HWADDR=$HWADDR\n"
+	}
+	return script
+}
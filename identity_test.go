@@ -0,0 +1,67 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path"
+
+	gc "launchpad.net/gocheck"
+)
+
+type IdentitySuite struct{}
+
+var _ = gc.Suite(&IdentitySuite{})
+
+func (*IdentitySuite) TestRandomizeKeepsHosts(c *gc.C) {
+	container := newTestContainer(c, Identity(EphemeralIdentity))
+	err := container.setupIdentity()
+	c.Assert(err, gc.IsNil)
+	err = container.SetHosts(map[string]string{"db": "10.0.3.20", "cache": "10.0.3.21"})
+	c.Assert(err, gc.IsNil)
+
+	out, err := exec.Command("/bin/sh", "-c", container.randomizeIdentityScript()).CombinedOutput()
+	c.Assert(err, gc.IsNil, gc.Commentf("%s", out))
+	hostname, err := ioutil.ReadFile(path.Join(container.identityDir(), "hostname"))
+	c.Assert(err, gc.IsNil)
+	hosts, err := ioutil.ReadFile(path.Join(container.identityDir(), "hosts"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(hosts), gc.Equals, `127.0.0.1 localhost
+127.0.1.1 `+string(hostname)+`::1 localhost ip6-localhost ip6-loopback
+# lxcify hosts
+10.0.3.21 cache
+10.0.3.20 db
+# end lxcify hosts
+`)
+}
+
+func (*IdentitySuite) TestRandomizeWithoutHosts(c *gc.C) {
+	container := newTestContainer(c, Identity(EphemeralIdentity))
+	err := container.setupIdentity()
+	c.Assert(err, gc.IsNil)
+
+	out, err := exec.Command("/bin/sh", "-c", container.randomizeIdentityScript()).CombinedOutput()
+	c.Assert(err, gc.IsNil, gc.Commentf("%s", out))
+	hosts, err := ioutil.ReadFile(path.Join(container.identityDir(), "hosts"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(hosts), gc.Matches, `127.0.0.1 localhost
+127.0.1.1 host-[0-9a-f]{6}
+::1 localhost ip6-localhost ip6-loopback
+`)
+}
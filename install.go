@@ -23,12 +23,23 @@ import (
 	"io"
 	"os"
 	"path"
+	"strings"
 	"text/template"
 
 	"github.com/juju/errors"
 )
 
 type App struct {
+	// Repositories are added, and Packages installed from them and the
+	// distro, before Downloads are fetched on the host and copied in. All
+	// of these happen before the install script runs.
+	Repositories []Repository
+	Packages     []string
+	Downloads    []Download
+
+	// Files are copied in after downloads.
+	Files []File
+
 	InstallScript   string
 	LaunchCommand   string
 	DesktopLauncher *DesktopLauncher
@@ -38,24 +49,29 @@ type DesktopLauncher struct {
 	Name     string
 	Comment  string
 	IconPath string
+
+	// Categories are freedesktop.org menu categories, in addition to
+	// LXCify.
+	Categories []string
 }
 
 const launchScript = `#!/bin/sh
 CONTAINER={{.Name}}
-CMD_LINE="{{.LaunchCommand}}"
+CMD_LINE={{shellquote .LaunchCommand}}
 
 STARTED=false
 
 if ! lxc-wait -n $CONTAINER -s RUNNING -t 0; then
-    lxc-start -n $CONTAINER -d
+{{.RandomizeIdentity}}    lxc-start -n $CONTAINER -d $START_OPTS
     lxc-wait -n $CONTAINER -s RUNNING
-    STARTED=true
+{{.VPNUp}}    STARTED=true
 fi
 
 PULSE_SOCKET=/home/ubuntu/.pulse_socket
 
 lxc-attach --clear-env -n $CONTAINER -- sudo -u ubuntu -i \
-    env DISPLAY=$DISPLAY PULSE_SERVER=$PULSE_SOCKET $CMD_LINE
+    env DISPLAY=$DISPLAY PULSE_SERVER=$PULSE_SOCKET {{range .Env}}{{shellquote .}} {{end}}\
+    sh -c "$CMD_LINE" lxcify "$@"
 
 if [ "$STARTED" = "true" ]; then
     lxc-stop -n $CONTAINER -t 10
@@ -69,10 +85,16 @@ Comment={{.Comment}}
 Exec={{.ConfigPath}}/{{.LxcName}}/launch.sh %U
 {{if .IconPath}}Icon={{.ConfigPath}}/{{.LxcName}}/rootfs{{.IconPath}}
 {{end}}Type=Application
-Categories=LXCify
+Categories=LXCify;{{range .ExtraCategories}}{{.}};{{end}}
 `
 
 func (c *Container) Install(app *App) error {
+	app = c.withVPNClient(app)
+	err := app.Check(c.distro)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	if !c.Running() {
 		err := c.Start()
 		if err != nil {
@@ -80,21 +102,35 @@ func (c *Container) Install(app *App) error {
 		}
 	}
 
-	// Execute install script in container
-	r, w, err := os.Pipe()
-	if err != nil {
-		return errors.Trace(err)
+	if c.gpu == NvidiaGPU {
+		err := c.setupNvidiaLibs()
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
-	defer r.Close()
-	go func() {
-		_, err := io.Copy(w, bytes.NewBufferString(app.InstallScript))
+
+	if c.vpn != nil {
+		err := c.installVPN()
 		if err != nil {
-			logger.Errorf("%v", errors.Trace(err))
-			return
+			return errors.Trace(err)
 		}
-		defer w.Close()
-	}()
-	err = c.RunCommand(r.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), "/bin/sh", "-c", "cat >/tmp/install.sh")
+	}
+
+	err = c.installPackages(app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = c.installDownloads(app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = c.installFiles(app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// Execute install script in container
+	err = c.writeContainerFile([]byte(app.InstallScript), "/tmp/install.sh")
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -117,6 +153,30 @@ func (c *Container) Install(app *App) error {
 		}
 	}
 
+	// Confine the installed application from its next start
+	if c.apparmor != nil {
+		err = c.SetConfigItem("lxc.aa_profile", c.AppArmorProfileName())
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// Installation needs the network before the tunnel can be brought up,
+	// so the kill switch only applies from the next start.
+	if c.vpn != nil {
+		err = c.setupKillSwitch()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if c.apparmor != nil || c.vpn != nil {
+		err = c.SaveConfigFile(c.ConfigFileName())
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	return nil
 }
 
@@ -127,13 +187,19 @@ func (c *Container) installLauncherScript(app *App) error {
 		return errors.Trace(err)
 	}
 	defer f.Close()
-	t, err := template.New("launch").Parse(launchScript)
+	t, err := template.New("launch").Funcs(template.FuncMap{
+		"shellquote": ShellQuote,
+	}).Parse(launchScript)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	err = t.Execute(f, struct {
 		Name, LaunchCommand string
-	}{c.Name(), app.LaunchCommand})
+		Env                 []string
+		RandomizeIdentity   string
+		VPNUp               string
+	}{c.Name(), app.LaunchCommand, c.network.proxyEnv(), indent(c.randomizeIdentityScript()),
+		indent(c.vpnUpScript())})
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -148,21 +214,102 @@ func (c *Container) installDesktopLauncher(app *App) error {
 		return errors.Trace(err)
 	}
 	defer f.Close()
+	var extraCategories []string
+	for _, category := range app.DesktopLauncher.Categories {
+		if category != "LXCify" {
+			extraCategories = append(extraCategories, category)
+		}
+	}
 	t, err := template.New("launch").Parse(desktopLauncher)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	err = t.Execute(f, struct {
 		*DesktopLauncher
-		ConfigPath string
-		LxcName    string
+		ConfigPath      string
+		LxcName         string
+		ExtraCategories []string
 	}{
 		DesktopLauncher: app.DesktopLauncher,
 		ConfigPath:      c.ConfigPath(),
 		LxcName:         c.Name(),
+		ExtraCategories: extraCategories,
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
 	return nil
 }
+
+// writeContainerFile writes a file in the running container, creating its
+// directory if necessary.
+func (c *Container) writeContainerFile(contents []byte, filename string) error {
+	return errors.Trace(c.pipeCommand(contents, "/bin/sh", "-c",
+		fmt.Sprintf("mkdir -p '%s' && umask 077 && cat >'%s'", path.Dir(filename), filename)))
+}
+
+// pipeCommand runs a command in the running container with contents as its
+// standard input.
+func (c *Container) pipeCommand(contents []byte, args ...string) error {
+	return errors.Trace(c.pipeReader(bytes.NewReader(contents), args...))
+}
+
+// pipeReader runs a command in the running container, copying its standard
+// input from in. An error reading in fails the command, even if it succeeds
+// with the input it was given.
+func (c *Container) pipeReader(in io.Reader, args ...string) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+	src := &sourceReader{Reader: in}
+	done := make(chan error, 1)
+	go func() {
+		// Write errors mean the command stopped reading, and are
+		// reported by the command.
+		io.Copy(w, src)
+		w.Close()
+		done <- src.err
+	}()
+	err = c.RunCommand(r.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), args...)
+	// Unblock the copy if the command exited without reading all of its
+	// input.
+	r.Close()
+	readErr := <-done
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotate(readErr, "cannot read command input")
+}
+
+// sourceReader records an error reading from its Reader.
+type sourceReader struct {
+	io.Reader
+	err error
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// ShellQuote quotes s as a single shell word.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// indent indents each line of a shell script fragment.
+func indent(script string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(script, "\n") {
+		if line != "" && line != "\n" {
+			line = "    " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "")
+}
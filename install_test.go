@@ -0,0 +1,61 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type InstallSuite struct{}
+
+var _ = gc.Suite(&InstallSuite{})
+
+// fakeLxcAttach runs the command after the sudo prefix on the host, as if
+// attached to a running container.
+const fakeLxcAttach = `#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+shift 5
+exec "$@"
+`
+
+func (*InstallSuite) TestLaunchCommand(c *gc.C) {
+	container := newTestContainer(c)
+	dir := filepath.Join(container.ConfigPath(), container.Name())
+	err := os.Mkdir(dir, 0700)
+	c.Assert(err, gc.IsNil)
+	err = container.installLauncherScript(&App{
+		LaunchCommand: `printf '<%s>' "it's" $* "$DISPLAY"`,
+	})
+	c.Assert(err, gc.IsNil)
+
+	binDir := c.MkDir()
+	err = ioutil.WriteFile(filepath.Join(binDir, "lxc-wait"), []byte("#!/bin/sh\nexit 0\n"), 0700)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(binDir, "lxc-attach"), []byte(fakeLxcAttach), 0700)
+	c.Assert(err, gc.IsNil)
+	cmd := exec.Command(filepath.Join(dir, "launch.sh"), "a b", "c")
+	cmd.Env = []string{"PATH=" + binDir + ":" + os.Getenv("PATH"), "DISPLAY=:0"}
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, gc.IsNil, gc.Commentf("%s", out))
+	c.Assert(string(out), gc.Equals, "<it's><a><b><c><:0>")
+}
@@ -0,0 +1,37 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	stdtesting "testing"
+
+	gc "launchpad.net/gocheck"
+)
+
+func Test(t *stdtesting.T) {
+	gc.TestingT(t)
+}
+
+// newTestContainer returns a container configured with options, which is not
+// created.
+func newTestContainer(c *gc.C, options ...Option) *Container {
+	options = append([]Option{ConfigPath(c.MkDir())}, options...)
+	container, err := NewContainer("test", options...)
+	c.Assert(err, gc.IsNil)
+	return container
+}
@@ -0,0 +1,275 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+)
+
+// CaptureKind identifies a type of capture device on the host.
+type CaptureKind string
+
+const (
+	CaptureCamera     CaptureKind = "camera"
+	CaptureMicrophone CaptureKind = "microphone"
+)
+
+var captureKinds = []CaptureKind{CaptureCamera, CaptureMicrophone}
+
+// CaptureEvent is emitted when a container starts or stops using a capture
+// device.
+type CaptureEvent struct {
+	Time      time.Time
+	Container string
+	Kind      CaptureKind
+	Active    bool
+}
+
+func (e CaptureEvent) String() string {
+	action := "stopped"
+	if e.Active {
+		action = "started"
+	}
+	return fmt.Sprintf("%s %s using the %s", e.Container, action, e.Kind)
+}
+
+// Monitor watches running lxcify containers for use of the camera and
+// microphone.
+type Monitor struct {
+	lxcpath  string
+	interval time.Duration
+	active   map[string]map[CaptureKind]bool
+}
+
+// NewMonitor returns a Monitor for the containers in lxcpath, which polls at
+// the given interval.
+func NewMonitor(lxcpath string, interval time.Duration) *Monitor {
+	return &Monitor{
+		lxcpath:  lxcpath,
+		interval: interval,
+		active:   make(map[string]map[CaptureKind]bool),
+	}
+}
+
+// Run polls containers until stop is closed, sending an event each time a
+// container starts or stops capturing.
+func (m *Monitor) Run(events chan<- CaptureEvent, stop <-chan struct{}) error {
+	for {
+		polled, err := m.Poll()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, event := range polled {
+			select {
+			case events <- event:
+			case <-stop:
+				return nil
+			}
+		}
+		select {
+		case <-time.After(m.interval):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Poll checks each running lxcify container once, returning events for any
+// changes in capture device use since the previous poll.
+func (m *Monitor) Poll() ([]CaptureEvent, error) {
+	now := time.Now()
+	current := make(map[string]map[CaptureKind]bool)
+
+	microphones, err := m.pulseCaptureSockets()
+	if err != nil {
+		logger.Debugf("cannot query pulseaudio: %v", err)
+	}
+	for _, name := range lxc.ActiveContainerNames(m.lxcpath) {
+		if !isLxcified(m.lxcpath, name) {
+			continue
+		}
+		c, err := lxc.NewContainer(name, m.lxcpath)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		camera, err := usesCamera(c.InitPid())
+		if err != nil {
+			logger.Debugf("cannot inspect processes in %q: %v", name, err)
+		}
+		current[name] = map[CaptureKind]bool{
+			CaptureCamera:     camera,
+			CaptureMicrophone: microphones[m.pulseSocket(name)],
+		}
+	}
+
+	return m.update(now, current), nil
+}
+
+// update records which capture devices each container is using, returning
+// events for the changes since the previous update.
+func (m *Monitor) update(now time.Time, current map[string]map[CaptureKind]bool) []CaptureEvent {
+	var events []CaptureEvent
+	for name, kinds := range current {
+		for _, kind := range captureKinds {
+			if kinds[kind] != m.active[name][kind] {
+				events = append(events, CaptureEvent{now, name, kind, kinds[kind]})
+			}
+		}
+	}
+	for name, kinds := range m.active {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		// The container stopped, so anything it was using has been released.
+		for _, kind := range captureKinds {
+			if kinds[kind] {
+				events = append(events, CaptureEvent{now, name, kind, false})
+			}
+		}
+	}
+	m.active = current
+	return events
+}
+
+func (m *Monitor) pulseSocket(name string) string {
+	return path.Join(m.lxcpath, name, "rootfs", "home", "ubuntu", ".pulse_socket")
+}
+
+var videoDevicePattern = regexp.MustCompile(`/dev/video[0-9]+$`)
+
+// usesCamera returns whether any process sharing a PID namespace with initPid
+// has a video device open.
+func usesCamera(initPid int) (bool, error) {
+	if initPid <= 0 {
+		return false, nil
+	}
+	pidns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", initPid))
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		procns, err := os.Readlink(path.Join("/proc", proc.Name(), "ns", "pid"))
+		if err != nil || procns != pidns {
+			continue
+		}
+		fdDir := path.Join("/proc", proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// Processes running as container root are not ours to inspect.
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(path.Join(fdDir, fd.Name()))
+			if err == nil && videoDevicePattern.MatchString(target) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// pulseCaptureSockets returns the set of PulseAudio native protocol sockets
+// which currently have clients recording from a source.
+func (m *Monitor) pulseCaptureSockets() (map[string]bool, error) {
+	var outputs [][]byte
+	for _, args := range [][]string{
+		{"list", "short", "modules"},
+		{"list", "clients"},
+		{"list", "short", "source-outputs"},
+	} {
+		out, err := exec.Command("pactl", args...).Output()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		outputs = append(outputs, out)
+	}
+	return parseCaptureSockets(outputs[0], outputs[1], outputs[2]), nil
+}
+
+// parseCaptureSockets returns the set of native protocol sockets with clients
+// recording from a source, from the output of pactl list short modules,
+// pactl list clients and pactl list short source-outputs.
+func parseCaptureSockets(modules, clients, sourceOutputs []byte) map[string]bool {
+	// Map the native protocol modules loaded for containers to their sockets.
+	moduleSockets := make(map[string]string)
+	for _, fields := range splitLines(modules) {
+		if len(fields) < 3 || fields[1] != "module-native-protocol-unix" {
+			continue
+		}
+		for _, arg := range strings.Fields(fields[2]) {
+			if strings.HasPrefix(arg, "socket=") {
+				moduleSockets[fields[0]] = strings.TrimPrefix(arg, "socket=")
+			}
+		}
+	}
+
+	// Map clients to the module that accepted their connection.
+	clientModules := make(map[string]string)
+	var client string
+	scanner := bufio.NewScanner(bytes.NewReader(clients))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Client #") {
+			client = strings.TrimPrefix(line, "Client #")
+		} else if strings.HasPrefix(line, "Owner Module:") && client != "" {
+			clientModules[client] = strings.TrimSpace(strings.TrimPrefix(line, "Owner Module:"))
+		}
+	}
+
+	capturing := make(map[string]bool)
+	for _, fields := range splitLines(sourceOutputs) {
+		if len(fields) < 3 {
+			continue
+		}
+		if socket, ok := moduleSockets[clientModules[fields[2]]]; ok {
+			capturing[socket] = true
+		}
+	}
+	return capturing
+}
+
+// splitLines splits tab-separated pactl output into fields.
+func splitLines(out []byte) [][]string {
+	var result [][]string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			result = append(result, strings.Split(line, "\t"))
+		}
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+type MonitorSuite struct{}
+
+var _ = gc.Suite(&MonitorSuite{})
+
+const pactlModules = "0\tmodule-device-restore\t\n" +
+	"24\tmodule-native-protocol-unix\t\n" +
+	"25\tmodule-native-protocol-unix\tauth-anonymous=1 socket=/var/lib/lxc/work/rootfs/home/ubuntu/.pulse_socket\n" +
+	"26\tmodule-native-protocol-unix\tauth-anonymous=1 socket=/var/lib/lxc/tor/rootfs/home/ubuntu/.pulse_socket\n"
+
+const pactlClients = `Client #7
+	Driver: protocol-native.c
+	Owner Module: 24
+	Properties:
+		application.name = "Firefox"
+Client #12
+	Driver: protocol-native.c
+	Owner Module: 25
+	Properties:
+		application.name = "Google Chrome input"
+Client #13
+	Driver: protocol-native.c
+	Owner Module: 26
+`
+
+const pactlSourceOutputs = "3\t1\t12\tprotocol-native.c\ts16le 1ch 44100Hz\n" +
+	"4\t1\t7\tprotocol-native.c\ts16le 2ch 44100Hz\n"
+
+func (*MonitorSuite) TestParseCaptureSockets(c *gc.C) {
+	capturing := parseCaptureSockets([]byte(pactlModules), []byte(pactlClients), []byte(pactlSourceOutputs))
+	c.Assert(capturing, gc.DeepEquals, map[string]bool{
+		"/var/lib/lxc/work/rootfs/home/ubuntu/.pulse_socket": true,
+	})
+	c.Assert(parseCaptureSockets(nil, nil, nil), gc.HasLen, 0)
+}
+
+func (*MonitorSuite) TestPulseSocket(c *gc.C) {
+	m := NewMonitor("/var/lib/lxc", time.Second)
+	c.Assert(m.pulseSocket("work"), gc.Equals, "/var/lib/lxc/work/rootfs/home/ubuntu/.pulse_socket")
+}
+
+func (*MonitorSuite) TestSplitLines(c *gc.C) {
+	c.Assert(splitLines([]byte("1\ta\tb c\n\n2\td\n")), gc.DeepEquals, [][]string{
+		{"1", "a", "b c"},
+		{"2", "d"},
+	})
+}
+
+func (*MonitorSuite) TestEventString(c *gc.C) {
+	c.Assert(CaptureEvent{Container: "work", Kind: CaptureCamera, Active: true}.String(), gc.Equals,
+		"work started using the camera")
+	c.Assert(CaptureEvent{Container: "tor", Kind: CaptureMicrophone}.String(), gc.Equals,
+		"tor stopped using the microphone")
+}
+
+type byEvent []CaptureEvent
+
+func (e byEvent) Len() int           { return len(e) }
+func (e byEvent) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byEvent) Less(i, j int) bool { return e[i].String() < e[j].String() }
+
+func (*MonitorSuite) TestUpdate(c *gc.C) {
+	m := NewMonitor("/var/lib/lxc", time.Second)
+	now := time.Now()
+	events := m.update(now, map[string]map[CaptureKind]bool{
+		"work": {CaptureCamera: true, CaptureMicrophone: true},
+		"tor":  {CaptureCamera: false, CaptureMicrophone: false},
+	})
+	sort.Sort(byEvent(events))
+	c.Assert(events, gc.DeepEquals, []CaptureEvent{
+		{now, "work", CaptureCamera, true},
+		{now, "work", CaptureMicrophone, true},
+	})
+
+	// Unchanged use is not reported again.
+	c.Assert(m.update(now, map[string]map[CaptureKind]bool{
+		"work": {CaptureCamera: true, CaptureMicrophone: true},
+	}), gc.HasLen, 0)
+
+	events = m.update(now, map[string]map[CaptureKind]bool{
+		"work": {CaptureCamera: false, CaptureMicrophone: true},
+	})
+	c.Assert(events, gc.DeepEquals, []CaptureEvent{{now, "work", CaptureCamera, false}})
+
+	// Stopped containers release their devices.
+	events = m.update(now, map[string]map[CaptureKind]bool{})
+	c.Assert(events, gc.DeepEquals, []CaptureEvent{{now, "work", CaptureMicrophone, false}})
+}
+
+func (*MonitorSuite) TestUsesCamera(c *gc.C) {
+	camera, err := usesCamera(0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(camera, gc.Equals, false)
+	camera, err = usesCamera(os.Getpid())
+	c.Assert(err, gc.IsNil)
+	c.Assert(camera, gc.Equals, false)
+	c.Assert(videoDevicePattern.MatchString("/dev/video0"), gc.Equals, true)
+	c.Assert(videoDevicePattern.MatchString("/dev/video0 (deleted)"), gc.Equals, false)
+	c.Assert(videoDevicePattern.MatchString("/dev/vhost-net"), gc.Equals, false)
+}
@@ -0,0 +1,217 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// NetworkMode selects how a container is connected to the network.
+type NetworkMode string
+
+const (
+	// NetworkDefault leaves the network configuration from the LXC default
+	// config in place.
+	NetworkDefault NetworkMode = ""
+
+	// NetworkNone gives the container a loopback interface only.
+	NetworkNone NetworkMode = "none"
+
+	// NetworkNAT attaches the container to a bridge which is NAT'd to the
+	// outside world, lxcbr0 by default.
+	NetworkNAT NetworkMode = "nat"
+
+	// NetworkHostOnly attaches the container to a bridge without a route off
+	// the host. The bridge must be given as the link, and serve addresses
+	// to the container.
+	NetworkHostOnly NetworkMode = "host-only"
+
+	// NetworkProxy attaches the container to the NAT bridge, and firewalls
+	// its traffic so that the only way out is through the proxy.
+	NetworkProxy NetworkMode = "proxy"
+
+	// NetworkTor attaches the container to the NAT bridge, and redirects all
+	// of its TCP and DNS traffic through a Tor client on the host. Other
+	// traffic is dropped.
+	NetworkTor NetworkMode = "tor"
+)
+
+const defaultNATLink = "lxcbr0"
+
+// NetworkPolicy describes a container's network.
+//
+// The bridge used must be allowed for the user in /etc/lxc/lxc-usernet.
+type NetworkPolicy struct {
+	Mode NetworkMode
+
+	// Link is the host bridge the container is attached to, overriding the
+	// default for the mode.
+	Link string
+
+	// Proxy is the URL of the SOCKS or HTTP proxy used in proxy mode, such
+	// as socks5://10.0.3.1:1080. Its host is resolved when the container
+	// starts.
+	Proxy string
+
+	// Limits limits the container's bandwidth.
+	Limits BandwidthLimits
+}
+
+var proxySchemes = map[string]bool{
+	"http": true, "https": true, "socks4": true, "socks5": true, "socks5h": true,
+}
+
+func Network(policy NetworkPolicy) Option {
+	return func(c *Container) error {
+		switch policy.Mode {
+		case NetworkDefault, NetworkNone, NetworkNAT, NetworkHostOnly, NetworkTor:
+			if policy.Proxy != "" {
+				return errors.Errorf("proxy is only supported in %q network mode", NetworkProxy)
+			}
+		case NetworkProxy:
+			_, err := policy.proxyRule()
+			if err != nil {
+				return errors.Trace(err)
+			}
+		default:
+			return errors.Errorf("unknown network mode %q", policy.Mode)
+		}
+		if policy.Link != "" && (policy.Mode == NetworkDefault || policy.Mode == NetworkNone) {
+			return errors.Errorf("link is not supported in %q network mode", policy.Mode)
+		}
+		if policy.Link == "" && policy.Mode == NetworkHostOnly {
+			return errors.Errorf("%q network mode requires a link", policy.Mode)
+		}
+		err := policy.Limits.validate()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if policy.Limits.enabled() && policy.Mode == NetworkNone {
+			return errors.Errorf("limits are not supported in %q network mode", policy.Mode)
+		}
+		c.network = policy
+		return nil
+	}
+}
+
+func (p NetworkPolicy) link() string {
+	if p.Link != "" {
+		return p.Link
+	}
+	return defaultNATLink
+}
+
+// proxyRule returns the firewall rule which allows traffic to the proxy.
+func (p NetworkPolicy) proxyRule() (FirewallRule, error) {
+	invalid := errors.Errorf("invalid proxy %q, expected scheme://host:port", p.Proxy)
+	u, err := url.Parse(p.Proxy)
+	if err != nil || !proxySchemes[u.Scheme] {
+		return FirewallRule{}, invalid
+	}
+	host, portString, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return FirewallRule{}, invalid
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return FirewallRule{}, invalid
+	}
+	rule := FirewallRule{Destination: host, Ports: []int{port}}
+	err = rule.validate()
+	if err != nil {
+		return FirewallRule{}, errors.Annotatef(err, "invalid proxy %q", p.Proxy)
+	}
+	return rule, nil
+}
+
+// proxyEnv returns the environment which directs applications to the proxy.
+func (p NetworkPolicy) proxyEnv() []string {
+	if p.Mode != NetworkProxy {
+		return nil
+	}
+	var env []string
+	for _, key := range []string{"http_proxy", "https_proxy", "ftp_proxy", "all_proxy"} {
+		env = append(env, key+"="+p.Proxy)
+	}
+	return append(env, "no_proxy=localhost,127.0.0.1")
+}
+
+// networkConfig returns the container config items for the network policy.
+func (c *Container) networkConfig() []lxcConfigItem {
+	if c.network.Mode == NetworkNone {
+		return []lxcConfigItem{{"lxc.network.type", "empty"}}
+	}
+	return []lxcConfigItem{
+		{"lxc.network.type", "veth"},
+		{"lxc.network.link", c.network.link()},
+		{"lxc.network.flags", "up"},
+		{"lxc.network.hwaddr", c.hwaddr()},
+	}
+}
+
+// setupNetwork replaces the network configuration inherited from the LXC
+// default config with the network policy.
+func (c *Container) setupNetwork() error {
+	if c.network.Mode == NetworkDefault {
+		return nil
+	}
+	err := c.ClearConfigItem("lxc.network")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.setLxcConfig(c.networkConfig()))
+}
+
+// networkTimeout is how long to wait for a container to get an address.
+const networkTimeout = 30 * time.Second
+
+// verifyNetwork checks that a started container's network matches its
+// network policy, waiting for it to get an address if it should have one.
+func (c *Container) verifyNetwork() error {
+	if c.network.Mode == NetworkNone {
+		ifaces, err := c.Interfaces()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, iface := range ifaces {
+			if iface != "lo" {
+				return errors.Errorf("network mode %q but container has interface %q", NetworkNone, iface)
+			}
+		}
+		return nil
+	}
+
+	deadline := time.Now().Add(networkTimeout)
+	for time.Now().Before(deadline) {
+		addrs, err := c.IPv4Addresses()
+		if err == nil && len(addrs) > 0 {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if c.network.Mode == NetworkDefault {
+		logger.Warningf("container %q has no network address", c.Name())
+		return nil
+	}
+	return errors.Errorf("timeout waiting for container network on %s", c.network.link())
+}
@@ -0,0 +1,219 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// GPUDriver selects how host graphics hardware is made available to a
+// container.
+type GPUDriver string
+
+const (
+	// DefaultGPU relies on the configured mounts, such as MountDRI, which is
+	// sufficient for Mesa drivers.
+	DefaultGPU GPUDriver = ""
+
+	// NvidiaGPU passes through the proprietary NVIDIA device nodes, along with
+	// the host's userspace driver libraries so that they match the host
+	// kernel module.
+	NvidiaGPU GPUDriver = "nvidia"
+)
+
+// nvidiaLibDir is where host NVIDIA libraries are mounted in the container.
+const nvidiaLibDir = "/usr/lib/lxcify/nvidia"
+
+var nvidiaLibPrefixes = []string{
+	"libnvidia-",
+	"libcuda.",
+	"libnvcuvid.",
+	"libnvoptix.",
+	"libGLX_nvidia.",
+	"libEGL_nvidia.",
+	"libGLESv1_CM_nvidia.",
+	"libGLESv2_nvidia.",
+	"libvdpau_nvidia.",
+}
+
+// nvidiaVendorFiles are loader configuration files which point the GL and
+// Vulkan loaders at the NVIDIA libraries.
+var nvidiaVendorFiles = []string{
+	"/usr/share/glvnd/egl_vendor.d/10_nvidia.json",
+	"/usr/share/vulkan/icd.d/nvidia_icd.json",
+	"/etc/vulkan/icd.d/nvidia_icd.json",
+	"/usr/share/egl/egl_external_platform.d/10_nvidia_wayland.json",
+}
+
+// nvidiaMounts returns mounts for the NVIDIA device nodes, driver libraries
+// and loader configuration currently installed on the host. Driver libraries
+// are selected to match the container architecture.
+func nvidiaMounts(arch string) ([]Mount, error) {
+	devices, err := filepath.Glob("/dev/nvidia*")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no NVIDIA devices found, is the nvidia kernel module loaded?")
+	}
+	var mounts []Mount
+	for _, device := range devices {
+		fi, err := os.Stat(device)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		mounts = append(mounts, PassthruMount(device, fi.IsDir()))
+	}
+
+	libs, err := nvidiaLibs(arch)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(libs) == 0 {
+		return nil, errors.Errorf("no NVIDIA driver libraries found for %s", arch)
+	}
+	for soname, hostPath := range libs {
+		mounts = append(mounts, Mount{
+			Host:      hostPath,
+			Container: path.Join(nvidiaLibDir, soname)[1:],
+			ReadOnly:  true,
+		})
+	}
+
+	for _, vendorFile := range nvidiaVendorFiles {
+		if _, err := os.Stat(vendorFile); err == nil {
+			mount := PassthruMount(vendorFile, false)
+			mount.ReadOnly = true
+			mounts = append(mounts, mount)
+		}
+	}
+	return mounts, nil
+}
+
+// ldconfigArch returns the architecture tag ldconfig reports for libraries
+// of the given architecture, and whether libraries can be selected by it at
+// all. 32-bit x86 libraries carry no tag.
+func ldconfigArch(arch string) (string, bool) {
+	switch arch {
+	case "amd64":
+		return "x86-64", true
+	case "i386":
+		return "", true
+	case "arm64":
+		return "AArch64", true
+	}
+	return "", false
+}
+
+// ldconfigEntry is a library listed by ldconfig -p.
+type ldconfigEntry struct {
+	soname string
+	arch   string
+	path   string
+}
+
+// parseLdconfigLine parses a line of ldconfig -p output, such as
+//
+//	libcuda.so.1 (libc6,x86-64, OS ABI: Linux 3.2.0) => /usr/lib/libcuda.so.1
+//
+// The header line and anything else not in this form is rejected.
+func parseLdconfigLine(line string) (ldconfigEntry, bool) {
+	fields := strings.SplitN(strings.TrimSpace(line), " => ", 2)
+	if len(fields) != 2 {
+		return ldconfigEntry{}, false
+	}
+	open := strings.Index(fields[0], "(")
+	if open < 0 || !strings.HasSuffix(fields[0], ")") {
+		return ldconfigEntry{}, false
+	}
+	soname := strings.TrimSpace(fields[0][:open])
+	if soname == "" || strings.ContainsAny(soname, " \t") {
+		return ldconfigEntry{}, false
+	}
+	entry := ldconfigEntry{soname: soname, path: strings.TrimSpace(fields[1])}
+	// The flags are the library type, then optionally the architecture,
+	// then optionally "OS ABI: ..." and "hwcap: ..." attributes.
+	flags := strings.Split(fields[0][open+1:len(fields[0])-1], ",")
+	if len(flags) > 1 {
+		if tag := strings.TrimSpace(flags[1]); !strings.Contains(tag, ":") {
+			entry.arch = tag
+		}
+	}
+	return entry, true
+}
+
+// nvidiaLibs returns the host's NVIDIA driver libraries known to ldconfig,
+// mapped from soname to the real path of the library.
+func nvidiaLibs(arch string) (map[string]string, error) {
+	out, err := exec.Command("ldconfig", "-p").Output()
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot list host libraries")
+	}
+	libs := make(map[string]string)
+	for soname, libPath := range selectNvidiaLibs(string(out), arch) {
+		realPath, err := filepath.EvalSymlinks(libPath)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		libs[soname] = realPath
+	}
+	return libs, nil
+}
+
+// selectNvidiaLibs returns the NVIDIA libraries for the given architecture
+// in ldconfig -p output, mapped from soname to path. ldconfig lists
+// preferred libraries first.
+func selectNvidiaLibs(ldconfigOutput, arch string) map[string]string {
+	tag, filter := ldconfigArch(arch)
+	libs := make(map[string]string)
+	for _, line := range strings.Split(ldconfigOutput, "\n") {
+		entry, ok := parseLdconfigLine(line)
+		if !ok || !isNvidiaLib(entry.soname) || (filter && entry.arch != tag) {
+			continue
+		}
+		if _, ok := libs[entry.soname]; ok {
+			continue
+		}
+		libs[entry.soname] = entry.path
+	}
+	return libs
+}
+
+func isNvidiaLib(soname string) bool {
+	for _, prefix := range nvidiaLibPrefixes {
+		if strings.HasPrefix(soname, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupNvidiaLibs adds the mounted host NVIDIA libraries to the container's
+// dynamic linker search path.
+func (c *Container) setupNvidiaLibs() error {
+	script := fmt.Sprintf("echo %s >/etc/ld.so.conf.d/00-lxcify-nvidia.conf && ldconfig", nvidiaLibDir)
+	err := c.RunCommand(os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), "/bin/sh", "-c", script)
+	return errors.Annotate(err, "cannot configure NVIDIA libraries")
+}
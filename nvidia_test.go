@@ -0,0 +1,98 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+type NvidiaSuite struct{}
+
+var _ = gc.Suite(&NvidiaSuite{})
+
+var testLdconfig = `1234 libs found in cache ` + "`/etc/ld.so.cache'" + `
+	libnvidia-glcore.so.550.54 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libnvidia-glcore.so.550.54
+	libnvidia-glcore.so.550.54 (libc6) => /usr/lib/i386-linux-gnu/libnvidia-glcore.so.550.54
+	libcuda.so.1 (libc6,x86-64, OS ABI: Linux 3.2.0) => /usr/lib/x86_64-linux-gnu/libcuda.so.1
+	libcuda.so.1 (libc6, OS ABI: Linux 3.2.0) => /usr/lib/i386-linux-gnu/libcuda.so.1
+	libcuda.so.1 (libc6,x86-64) => /usr/local/lib/libcuda.so.1
+	libGLX_nvidia.so.0 (libc6,AArch64) => /usr/lib/aarch64-linux-gnu/libGLX_nvidia.so.0
+	libGL.so.1 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libGL.so.1
+
+	 => /nowhere
+	libbroken.so (libc6,x86-64 => /usr/lib/libbroken.so
+Cache generated by: ldconfig (GNU libc) stable release version 2.39
+`
+
+func (*NvidiaSuite) TestParseLdconfigLine(c *gc.C) {
+	testCases := []struct {
+		line  string
+		entry ldconfigEntry
+		ok    bool
+	}{{
+		line:  "\tlibcuda.so.1 (libc6,x86-64) => /usr/lib/libcuda.so.1",
+		entry: ldconfigEntry{"libcuda.so.1", "x86-64", "/usr/lib/libcuda.so.1"},
+		ok:    true,
+	}, {
+		line:  "libcuda.so.1 (libc6) => /usr/lib32/libcuda.so.1",
+		entry: ldconfigEntry{"libcuda.so.1", "", "/usr/lib32/libcuda.so.1"},
+		ok:    true,
+	}, {
+		line:  "libcuda.so.1 (libc6, OS ABI: Linux 3.2.0) => /usr/lib32/libcuda.so.1",
+		entry: ldconfigEntry{"libcuda.so.1", "", "/usr/lib32/libcuda.so.1"},
+		ok:    true,
+	}, {
+		line:  "libc.so.6 (libc6,hard-float, hwcap: 0x0000000000000010) => /lib/libc.so.6",
+		entry: ldconfigEntry{"libc.so.6", "hard-float", "/lib/libc.so.6"},
+		ok:    true,
+	}, {
+		line: "",
+	}, {
+		line: "   ",
+	}, {
+		line: " => /nowhere",
+	}, {
+		line: "(libc6) => /nowhere",
+	}, {
+		line: "libcuda.so.1 => /usr/lib/libcuda.so.1",
+	}, {
+		line: "1234 libs found in cache `/etc/ld.so.cache'",
+	}}
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		entry, ok := parseLdconfigLine(testCase.line)
+		c.Assert(ok, gc.Equals, testCase.ok)
+		c.Assert(entry, gc.Equals, testCase.entry)
+	}
+}
+
+func (*NvidiaSuite) TestSelectNvidiaLibs(c *gc.C) {
+	c.Assert(selectNvidiaLibs(testLdconfig, "amd64"), gc.DeepEquals, map[string]string{
+		"libnvidia-glcore.so.550.54": "/usr/lib/x86_64-linux-gnu/libnvidia-glcore.so.550.54",
+		"libcuda.so.1":               "/usr/lib/x86_64-linux-gnu/libcuda.so.1",
+	})
+	c.Assert(selectNvidiaLibs(testLdconfig, "i386"), gc.DeepEquals, map[string]string{
+		"libnvidia-glcore.so.550.54": "/usr/lib/i386-linux-gnu/libnvidia-glcore.so.550.54",
+		"libcuda.so.1":               "/usr/lib/i386-linux-gnu/libcuda.so.1",
+	})
+	c.Assert(selectNvidiaLibs(testLdconfig, "arm64"), gc.DeepEquals, map[string]string{
+		"libGLX_nvidia.so.0": "/usr/lib/aarch64-linux-gnu/libGLX_nvidia.so.0",
+	})
+	c.Assert(selectNvidiaLibs(testLdconfig, "armhf"), gc.HasLen, 3)
+	c.Assert(selectNvidiaLibs("", "amd64"), gc.HasLen, 0)
+}
@@ -0,0 +1,250 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Repository is an apt repository, added with its signing key.
+type Repository struct {
+	// Name names the repository's sources list and keyring.
+	Name string
+
+	// URL is the repository's base URL.
+	URL string
+
+	// Suite is the distribution suite, such as stable or trusty.
+	Suite string
+
+	// Components are the repository components, such as main.
+	Components []string
+
+	// Key is the URL of the key the repository is signed with, binary or
+	// ASCII-armored. It must be https unless KeySHA256 is given.
+	Key string
+
+	// KeySHA256, if given, is the hex SHA-256 of the key.
+	KeySHA256 string
+}
+
+// Download is a file fetched on the host, verified, and copied into the
+// container.
+type Download struct {
+	// URL is where the file is fetched from. A file:// URL names a file on
+	// the host, for offline installs.
+	URL string
+
+	// SHA256 is the hex SHA-256 the file must have.
+	SHA256 string
+
+	// Path is the absolute path of the file in the container.
+	Path string
+
+	// Mode is the permissions of the file in the container, 0644 if unset.
+	Mode os.FileMode
+}
+
+var (
+	packagePattern        = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+(:[a-z0-9]+)?(=[A-Za-z0-9.+~:-]+)?$`)
+	repositoryNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+	suitePattern          = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	urlPattern            = regexp.MustCompile(`^https?://[^\s'"\\]+$`)
+	downloadURLPattern    = regexp.MustCompile(`^(https?://[^\s'"\\]+|file:///.*)$`)
+	sha256Pattern         = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	containerPathPattern  = regexp.MustCompile(`^/[^\s'"\\]*$`)
+)
+
+// aptDistros are the distributions whose packages are managed with apt.
+var aptDistros = []string{"ubuntu", "debian"}
+
+// Check checks that an app's packages, repositories, downloads and files are
+// valid, and can be installed on a distro.
+func (app *App) Check(distro string) error {
+	if len(app.Packages) > 0 || len(app.Repositories) > 0 {
+		supported := false
+		for _, d := range aptDistros {
+			supported = supported || d == distro
+		}
+		if !supported {
+			return errors.Errorf("packages are not supported on distro %q, expected one of %s",
+				distro, strings.Join(aptDistros, ", "))
+		}
+	}
+	for _, pkg := range app.Packages {
+		if !packagePattern.MatchString(pkg) {
+			return errors.Errorf("invalid package %q", pkg)
+		}
+	}
+	names := make(map[string]bool)
+	for _, repo := range app.Repositories {
+		if !repositoryNamePattern.MatchString(repo.Name) {
+			return errors.Errorf("invalid repository name %q", repo.Name)
+		}
+		if names[repo.Name] {
+			return errors.Errorf("duplicate repository %q", repo.Name)
+		}
+		names[repo.Name] = true
+		if !urlPattern.MatchString(repo.URL) {
+			return errors.Errorf("repository %q: invalid url %q", repo.Name, repo.URL)
+		}
+		if !suitePattern.MatchString(repo.Suite) {
+			return errors.Errorf("repository %q: invalid suite %q", repo.Name, repo.Suite)
+		}
+		for _, component := range repo.Components {
+			if !suitePattern.MatchString(component) {
+				return errors.Errorf("repository %q: invalid component %q", repo.Name, component)
+			}
+		}
+		if !urlPattern.MatchString(repo.Key) {
+			return errors.Errorf("repository %q: invalid key url %q", repo.Name, repo.Key)
+		}
+		if repo.KeySHA256 != "" && !sha256Pattern.MatchString(repo.KeySHA256) {
+			return errors.Errorf("repository %q: invalid key sha256 %q", repo.Name, repo.KeySHA256)
+		}
+		if repo.KeySHA256 == "" && !strings.HasPrefix(repo.Key, "https://") {
+			return errors.Errorf("repository %q: key url %q must be https, or key-sha256 given",
+				repo.Name, repo.Key)
+		}
+	}
+	for _, d := range app.Downloads {
+		if !downloadURLPattern.MatchString(d.URL) {
+			return errors.Errorf("invalid download url %q, expected http, https or file:///path", d.URL)
+		}
+		if !sha256Pattern.MatchString(d.SHA256) {
+			return errors.Errorf("download %s: invalid sha256 %q, expected 64 hex digits", d.URL, d.SHA256)
+		}
+		if !containerPathPattern.MatchString(d.Path) {
+			return errors.Errorf("download %s: invalid path %q, expected an absolute path", d.URL, d.Path)
+		}
+		if d.Mode&^os.ModePerm != 0 {
+			return errors.Errorf("download %s: invalid mode %v", d.URL, d.Mode)
+		}
+	}
+	for _, f := range app.Files {
+		err := f.check()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// installStep is a command run in the container to install an app.
+type installStep struct {
+	description string
+	script      string
+}
+
+// aptEnv keeps apt from prompting.
+const aptEnv = "export DEBIAN_FRONTEND=noninteractive\n"
+
+// unmountX11Script unmounts the X11 socket directory before packages are
+// installed. Packages such as x11-common change its ownership and mode, which
+// would change the host's through a passthrough mount. It is mounted again
+// when the container next starts.
+var unmountX11Script = fmt.Sprintf("if grep -qs ' %[1]s ' /proc/mounts; then umount %[1]s; fi\n",
+	"/"+MountX11.Container)
+
+// installSteps returns the steps which install an app's repositories and
+// packages, in order.
+func installSteps(app *App) []installStep {
+	var steps []installStep
+	updated := false
+	if len(app.Repositories) > 0 {
+		steps = append(steps, installStep{
+			"cannot install repository tools",
+			aptEnv + "apt-get update\napt-get install -y --no-install-recommends wget ca-certificates gnupg\n",
+		})
+		updated = true
+	}
+	for _, repo := range app.Repositories {
+		steps = append(steps, installStep{
+			fmt.Sprintf("cannot add repository %q", repo.Name),
+			repositoryScript(repo),
+		})
+		updated = false
+	}
+	if len(app.Packages) > 0 {
+		script := unmountX11Script
+		if !updated {
+			script += "apt-get update\n"
+		}
+		script += "apt-get install -y --no-install-recommends " + strings.Join(app.Packages, " ") + "\n"
+		steps = append(steps, installStep{
+			fmt.Sprintf("cannot install packages %s", strings.Join(app.Packages, ", ")),
+			aptEnv + script,
+		})
+	}
+	return steps
+}
+
+func repositoryKeyring(name string) string {
+	return fmt.Sprintf("/etc/apt/trusted.gpg.d/lxcify-%s.gpg", name)
+}
+
+// repositoryScript fetches a repository's key into its own keyring in apt's
+// trusted keyrings. Restricting a key to its repository with signed-by needs
+// apt 1.1, which releases such as trusty predate.
+func repositoryScript(repo Repository) string {
+	keyring := repositoryKeyring(repo.Name)
+	script := downloadScript(repo.Key, repo.KeySHA256, "/tmp/lxcify-key")
+	script += fmt.Sprintf(`if grep -q 'BEGIN PGP PUBLIC KEY BLOCK' /tmp/lxcify-key; then
+    gpg --dearmor </tmp/lxcify-key >'%[1]s'
+else
+    cp /tmp/lxcify-key '%[1]s'
+fi
+chmod 644 '%[1]s'
+rm -f /tmp/lxcify-key
+echo 'deb %[2]s %[3]s %[4]s' >'/etc/apt/sources.list.d/lxcify-%[5]s.list'
+`, keyring, repo.URL, repo.Suite, strings.Join(repo.Components, " "), repo.Name)
+	return script
+}
+
+// downloadScript downloads a file in the container, and checks its SHA-256
+// if given.
+func downloadScript(url, sha256, filename string) string {
+	script := fmt.Sprintf("mkdir -p '%s'\nwget -nv -O '%s' '%s'\n", path.Dir(filename), filename, url)
+	if sha256 != "" {
+		script += fmt.Sprintf(`if ! echo '%[1]s  %[2]s' | sha256sum -c --quiet; then
+    rm -f '%[2]s'
+    echo 'sha256 mismatch for %[3]s' >&2
+    exit 1
+fi
+`, sha256, filename, url)
+	}
+	return script
+}
+
+// installPackages runs an app's install steps in the container.
+func (c *Container) installPackages(app *App) error {
+	for _, step := range installSteps(app) {
+		err := c.RunCommand(os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(),
+			"/bin/sh", "-ec", step.script)
+		if err != nil {
+			return errors.Annotate(err, step.description)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+type PackagesSuite struct{}
+
+var _ = gc.Suite(&PackagesSuite{})
+
+func (*PackagesSuite) TestRepositoryScript(c *gc.C) {
+	script := repositoryScript(Repository{
+		Name:       "google-chrome",
+		URL:        "https://dl.google.com/linux/chrome/deb/",
+		Suite:      "stable",
+		Components: []string{"main"},
+		Key:        "https://dl.google.com/linux/linux_signing_key.pub",
+	})
+	// apt before 1.1 only trusts keys in its trusted keyrings, and does not
+	// support signed-by.
+	c.Assert(script, gc.Matches, `(?s).*gpg --dearmor </tmp/lxcify-key >'/etc/apt/trusted.gpg.d/lxcify-google-chrome.gpg'\n.*`)
+	c.Assert(script, gc.Not(gc.Matches), `(?s).*signed-by.*`)
+	c.Assert(strings.HasSuffix(script,
+		"echo 'deb https://dl.google.com/linux/chrome/deb/ stable main' >'/etc/apt/sources.list.d/lxcify-google-chrome.list'\n"),
+		gc.Equals, true, gc.Commentf("%s", script))
+}
+
+func (*PackagesSuite) TestInstallSteps(c *gc.C) {
+	steps := installSteps(&App{
+		Repositories: []Repository{{
+			Name:      "example",
+			URL:       "http://example.com/deb",
+			Suite:     "stable",
+			Key:       "http://example.com/key",
+			KeySHA256: "0f343b0931126a20f133d67c2b018a3b4b7bfb7e1e6a3dd2a6a3b5d4f1c7e0d1",
+		}},
+		Packages: []string{"example"},
+	})
+	c.Assert(steps, gc.HasLen, 3)
+	c.Assert(steps[1].script, gc.Matches, `(?s)mkdir -p '/tmp'\nwget -nv -O '/tmp/lxcify-key' 'http://example.com/key'\n`+
+		`if ! echo '0f343b0931126a20f133d67c2b018a3b4b7bfb7e1e6a3dd2a6a3b5d4f1c7e0d1  /tmp/lxcify-key' \| sha256sum -c --quiet; then.*`)
+	c.Assert(steps[2].script, gc.Equals, aptEnv+
+		"if grep -qs ' /tmp/.X11-unix ' /proc/mounts; then umount /tmp/.X11-unix; fi\n"+
+		"apt-get update\napt-get install -y --no-install-recommends example\n")
+	c.Assert(steps[0].script, gc.Not(gc.Matches), "(?s).*umount.*")
+}
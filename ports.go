@@ -0,0 +1,226 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/lxc/go-lxc.v1"
+)
+
+// PortForward forwards a TCP port on the host's loopback interface to a port
+// in the container.
+type PortForward struct {
+	HostPort, ContainerPort int
+}
+
+func (p PortForward) String() string {
+	return fmt.Sprintf("127.0.0.1:%d->%d", p.HostPort, p.ContainerPort)
+}
+
+// Ports forwards host ports to the container while it runs. Forwarding is
+// done by a userspace forwarder, "lxcify forward", started and stopped by
+// container hooks. The lxcify command must be in the PATH of the user
+// starting the container.
+func Ports(forwards ...PortForward) Option {
+	return func(c *Container) error {
+		for _, forward := range forwards {
+			if forward.HostPort < 1 || forward.HostPort > 65535 ||
+				forward.ContainerPort < 1 || forward.ContainerPort > 65535 {
+				return errors.Errorf("invalid port forward %d:%d", forward.HostPort, forward.ContainerPort)
+			}
+		}
+		c.ports = append(c.ports, forwards...)
+		return nil
+	}
+}
+
+func (c *Container) portsFile() string {
+	return path.Join(c.ConfigPath(), c.Name(), "ports")
+}
+
+// forwardUpScript starts the forwarder. The lxcify command is found when the
+// container starts, rather than recording the path of the command which
+// created it, which may since have been moved, upgraded or removed.
+const forwardUpScript = `#!/bin/sh
+LXCIFY=$(command -v lxcify) || {
+    echo "cannot find lxcify in PATH to forward ports" >&2
+    exit 1
+}
+nohup "$LXCIFY" forward %[1]q >%[2]q/forward.log 2>&1 &
+echo $! >%[2]q/forward.pid
+`
+
+const forwardDownScript = `#!/bin/sh
+PID_FILE=%q/forward.pid
+if [ -e "$PID_FILE" ]; then
+    kill $(cat $PID_FILE) 2>/dev/null || true
+    rm -f $PID_FILE
+fi
+`
+
+// setupPorts records the container's port forwards, and installs hooks which
+// run the forwarder while the container is running.
+func (c *Container) setupPorts() error {
+	f, err := os.OpenFile(c.portsFile(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	for _, forward := range c.ports {
+		_, err = fmt.Fprintf(f, "%d:%d\n", forward.HostPort, forward.ContainerPort)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	dir := path.Join(c.ConfigPath(), c.Name())
+	err = c.installHook("pre-start", "forward-up.sh", fmt.Sprintf(forwardUpScript, c.Name(), dir))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.installHook("post-stop", "forward-down.sh", fmt.Sprintf(forwardDownScript, dir)))
+}
+
+// PortForwards returns the port forwards recorded for the container.
+func (c *Container) PortForwards() ([]PortForward, error) {
+	f, err := os.Open(c.portsFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	var forwards []PortForward
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var forward PortForward
+		_, err := fmt.Sscanf(scanner.Text(), "%d:%d", &forward.HostPort, &forward.ContainerPort)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid port forward %q", scanner.Text())
+		}
+		forwards = append(forwards, forward)
+	}
+	return forwards, errors.Trace(scanner.Err())
+}
+
+// forwardStartTimeout is how long the forwarder waits for the container to
+// start running.
+const forwardStartTimeout = time.Minute
+
+// Forward runs the container's port forwards until the container stops, or
+// stop is closed. An error is returned if a port cannot be forwarded.
+func (c *Container) Forward(stop <-chan struct{}) error {
+	forwards, err := c.PortForwards()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	// Listeners are only closed on return, so any error accepting
+	// connections ends forwarding.
+	acceptErrs := make(chan error, len(forwards))
+	for _, forward := range forwards {
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", forward.HostPort))
+		if err != nil {
+			return errors.Annotatef(err, "cannot forward %s", forward)
+		}
+		listeners = append(listeners, l)
+		go func(forward PortForward) {
+			acceptErrs <- errors.Annotatef(c.forward(l, forward.ContainerPort), "cannot forward %s", forward)
+		}(forward)
+	}
+
+	// The forwarder is started before the container, so wait for it to
+	// start before watching for it to stop.
+	started := false
+	deadline := time.Now().Add(forwardStartTimeout)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-acceptErrs:
+			return errors.Trace(err)
+		case <-time.After(time.Second):
+		}
+		state := c.State()
+		if state == lxc.RUNNING {
+			started = true
+		} else if started || time.Now().After(deadline) {
+			logger.Infof("container %q is %s, stopping port forwarding", c.Name(), state)
+			return nil
+		}
+	}
+}
+
+// forward accepts connections on l and forwards them to the port in the
+// container, until accepting fails.
+func (c *Container) forward(l net.Listener, port int) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		go func() {
+			defer conn.Close()
+			addrs, err := c.IPv4Addresses()
+			if err != nil || len(addrs) == 0 {
+				logger.Errorf("cannot forward to container %q: no address", c.Name())
+				return
+			}
+			target, err := net.Dial("tcp", net.JoinHostPort(addrs[0], fmt.Sprint(port)))
+			if err != nil {
+				logger.Errorf("cannot forward to container %q: %v", c.Name(), err)
+				return
+			}
+			defer target.Close()
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				io.Copy(target, conn)
+				closeWrite(target)
+			}()
+			go func() {
+				defer wg.Done()
+				io.Copy(conn, target)
+				closeWrite(conn)
+			}()
+			wg.Wait()
+		}()
+	}
+}
+
+// closeWrite half-closes a TCP connection so that the peer sees EOF.
+func closeWrite(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+}
@@ -0,0 +1,104 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+type PortsSuite struct{}
+
+var _ = gc.Suite(&PortsSuite{})
+
+func (*PortsSuite) TestPortForwards(c *gc.C) {
+	container := newTestContainer(c, Ports(PortForward{8384, 8384}, PortForward{8080, 80}))
+	err := os.Mkdir(filepath.Join(container.ConfigPath(), container.Name()), 0700)
+	c.Assert(err, gc.IsNil)
+	forwards, err := container.PortForwards()
+	c.Assert(err, gc.IsNil)
+	c.Assert(forwards, gc.HasLen, 0)
+
+	err = ioutil.WriteFile(container.portsFile(), []byte("8384:8384\n8080:80\n"), 0600)
+	c.Assert(err, gc.IsNil)
+	forwards, err = container.PortForwards()
+	c.Assert(err, gc.IsNil)
+	c.Assert(forwards, gc.DeepEquals, []PortForward{{8384, 8384}, {8080, 80}})
+
+	err = ioutil.WriteFile(container.portsFile(), []byte("8384\n"), 0600)
+	c.Assert(err, gc.IsNil)
+	_, err = container.PortForwards()
+	c.Assert(err, gc.ErrorMatches, `invalid port forward "8384": .*`)
+}
+
+func (*PortsSuite) TestUpScript(c *gc.C) {
+	dir := c.MkDir()
+	binDir, log := filepath.Join(dir, "bin"), filepath.Join(dir, "commands.log")
+	err := os.Mkdir(binDir, 0700)
+	c.Assert(err, gc.IsNil)
+
+	// lxcify is found in the PATH when the hook runs.
+	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf(forwardUpScript, "app", dir))
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":/usr/bin:/bin")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, gc.NotNil)
+	c.Assert(string(out), gc.Equals, "cannot find lxcify in PATH to forward ports\n")
+
+	fakeCommand(c, binDir, "lxcify", log, "")
+	cmd = exec.Command("/bin/sh", "-c", fmt.Sprintf(forwardUpScript, "app", dir))
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":/usr/bin:/bin")
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, gc.IsNil, gc.Commentf("%s", out))
+	_, err = os.Stat(filepath.Join(dir, "forward.pid"))
+	c.Assert(err, gc.IsNil)
+	var commands []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if commands, err = ioutil.ReadFile(log); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(string(commands), gc.Equals, "lxcify forward app\n")
+}
+
+func (*PortsSuite) TestForwardAcceptError(c *gc.C) {
+	container := newTestContainer(c)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gc.IsNil)
+	l.Close()
+	err = container.forward(l, 80)
+	c.Assert(err, gc.ErrorMatches, ".*use of closed network connection")
+}
+
+func (*PortsSuite) TestIsLxcified(c *gc.C) {
+	lxcpath := c.MkDir()
+	c.Assert(isLxcified(lxcpath, "app"), gc.Equals, false)
+	err := os.Mkdir(filepath.Join(lxcpath, "app"), 0700)
+	c.Assert(err, gc.IsNil)
+	c.Assert(isLxcified(lxcpath, "app"), gc.Equals, false)
+	err = ioutil.WriteFile(filepath.Join(lxcpath, "app", "launch.sh"), nil, 0700)
+	c.Assert(err, gc.IsNil)
+	c.Assert(isLxcified(lxcpath, "app"), gc.Equals, true)
+}
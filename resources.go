@@ -0,0 +1,422 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Resources limits the host resources a container may use. Zero values are
+// left unlimited.
+type Resources struct {
+	// Memory limits memory use, in bytes or with a K, M or G suffix.
+	Memory string
+
+	// MemorySwap limits memory and swap use together, and must be at least
+	// Memory.
+	MemorySwap string
+
+	// CPUShares is the container's relative CPU weight, 1024 being the
+	// default for cgroups.
+	CPUShares int
+
+	// CPUQuota limits the container to a number of CPUs' time, such as 1.5.
+	CPUQuota float64
+
+	// CPUSet restricts the container to CPUs, such as "0-3" or "0,2".
+	CPUSet string
+
+	// Pids limits the number of processes.
+	Pids int
+
+	// BlkioWeight is the container's relative block I/O weight, from 10
+	// to 1000.
+	BlkioWeight int
+}
+
+const (
+	// cpuPeriod is the CFS period CPU quotas are expressed in, in
+	// microseconds.
+	cpuPeriod = 100000
+
+	// minCPUQuota is the smallest CFS quota the kernel accepts, in
+	// microseconds.
+	minCPUQuota = 1000
+)
+
+var (
+	sizeSuffixes  = map[string]int64{"": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}
+	memoryPattern = regexp.MustCompile(`^([0-9]+)([kmgt]?)b?$`)
+	cpuSetPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+)
+
+// parseMemory parses a memory size into bytes.
+func parseMemory(s string) (int64, error) {
+	m := memoryPattern.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, errors.Errorf("invalid memory size %q", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n == 0 {
+		return 0, errors.Errorf("invalid memory size %q", s)
+	}
+	return n * sizeSuffixes[m[2]], nil
+}
+
+func (r *Resources) validate() error {
+	var memory int64
+	if r.Memory != "" {
+		var err error
+		memory, err = parseMemory(r.Memory)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if r.MemorySwap != "" {
+		if r.Memory == "" {
+			return errors.New("memory-swap requires memory")
+		}
+		memorySwap, err := parseMemory(r.MemorySwap)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if memorySwap < memory {
+			return errors.Errorf("memory-swap %q is less than memory %q", r.MemorySwap, r.Memory)
+		}
+	}
+	if r.CPUShares < 0 || r.CPUShares == 1 || r.CPUShares > 262144 {
+		return errors.Errorf("invalid cpu-shares %d, expected 2 to 262144", r.CPUShares)
+	}
+	if r.CPUQuota < 0 {
+		return errors.Errorf("invalid cpu-quota %v", r.CPUQuota)
+	}
+	if r.CPUQuota != 0 && int(r.CPUQuota*cpuPeriod) < minCPUQuota {
+		return errors.Errorf("cpu-quota %v is less than the minimum of %v",
+			r.CPUQuota, float64(minCPUQuota)/cpuPeriod)
+	}
+	if r.CPUSet != "" && !cpuSetPattern.MatchString(r.CPUSet) {
+		return errors.Errorf("invalid cpuset %q", r.CPUSet)
+	}
+	if r.Pids < 0 {
+		return errors.Errorf("invalid pids %d", r.Pids)
+	}
+	if r.BlkioWeight != 0 && (r.BlkioWeight < 10 || r.BlkioWeight > 1000) {
+		return errors.Errorf("invalid blkio-weight %d, expected 10 to 1000", r.BlkioWeight)
+	}
+	return nil
+}
+
+// Set sets a resource limit by its template key, such as "memory" or
+// "cpu-quota".
+func (r *Resources) Set(key, value string) error {
+	var err error
+	switch key {
+	case "memory":
+		r.Memory = value
+	case "memory-swap":
+		r.MemorySwap = value
+	case "cpu-shares":
+		r.CPUShares, err = strconv.Atoi(value)
+	case "cpu-quota":
+		r.CPUQuota, err = strconv.ParseFloat(value, 64)
+	case "cpuset":
+		r.CPUSet = value
+	case "pids":
+		r.Pids, err = strconv.Atoi(value)
+	case "blkio-weight":
+		r.BlkioWeight, err = strconv.Atoi(value)
+	default:
+		return errors.Errorf("unknown resource %q", key)
+	}
+	if err != nil {
+		return errors.Errorf("invalid %s %q", key, value)
+	}
+	return nil
+}
+
+func ResourceLimits(r *Resources) Option {
+	return func(c *Container) error {
+		err := r.validate()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		c.resources = r
+		return nil
+	}
+}
+
+// cgroupV2 returns whether the host uses the unified cgroup hierarchy.
+func cgroupV2() bool {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	return err == nil
+}
+
+// cgroupPrefix returns the prefix of cgroup config keys.
+func cgroupPrefix(v2 bool) string {
+	if v2 {
+		return "lxc.cgroup2."
+	}
+	return "lxc.cgroup."
+}
+
+// cgroupItems returns the cgroup controller settings for the limits, without
+// the config key prefix. Settings are converted to their cgroup2 equivalents
+// when v2 is true.
+func (r *Resources) cgroupItems(v2 bool) ([]lxcConfigItem, error) {
+	err := r.validate()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var items []lxcConfigItem
+	if r.Memory != "" {
+		memory, _ := parseMemory(r.Memory)
+		if v2 {
+			items = append(items, lxcConfigItem{"memory.max", strconv.FormatInt(memory, 10)})
+		} else {
+			items = append(items, lxcConfigItem{"memory.limit_in_bytes", strconv.FormatInt(memory, 10)})
+		}
+		if r.MemorySwap != "" {
+			memorySwap, _ := parseMemory(r.MemorySwap)
+			if v2 {
+				// cgroup2 limits swap separately.
+				items = append(items, lxcConfigItem{"memory.swap.max", strconv.FormatInt(memorySwap-memory, 10)})
+			} else {
+				items = append(items, lxcConfigItem{memswKey, strconv.FormatInt(memorySwap, 10)})
+			}
+		}
+	}
+	if r.CPUShares != 0 {
+		if v2 {
+			// Map shares [2, 262144] onto weights [1, 10000], as runc
+			// does.
+			weight := 1 + (r.CPUShares-2)*9999/262142
+			items = append(items, lxcConfigItem{"cpu.weight", strconv.Itoa(weight)})
+		} else {
+			items = append(items, lxcConfigItem{"cpu.shares", strconv.Itoa(r.CPUShares)})
+		}
+	}
+	if r.CPUQuota != 0 {
+		quota := int(r.CPUQuota * cpuPeriod)
+		if v2 {
+			items = append(items, lxcConfigItem{"cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)})
+		} else {
+			items = append(items,
+				lxcConfigItem{"cpu.cfs_period_us", strconv.Itoa(cpuPeriod)},
+				lxcConfigItem{"cpu.cfs_quota_us", strconv.Itoa(quota)})
+		}
+	}
+	if r.CPUSet != "" {
+		items = append(items, lxcConfigItem{"cpuset.cpus", r.CPUSet})
+	}
+	if r.Pids != 0 {
+		items = append(items, lxcConfigItem{"pids.max", strconv.Itoa(r.Pids)})
+	}
+	if r.BlkioWeight != 0 {
+		if v2 {
+			// Scale blkio weights so that the defaults, 500 and 100, are
+			// equivalent.
+			weight := r.BlkioWeight / 5
+			items = append(items, lxcConfigItem{"io.weight", fmt.Sprintf("default %d", weight)})
+		} else {
+			items = append(items, lxcConfigItem{"blkio.weight", strconv.Itoa(r.BlkioWeight)})
+		}
+	}
+	return items, nil
+}
+
+// resourceConfig returns the container config items for its resource
+// limits.
+func (c *Container) resourceConfig() ([]lxcConfigItem, error) {
+	if c.resources == nil {
+		return nil, nil
+	}
+	v2 := cgroupV2()
+	items, err := c.resources.cgroupItems(v2)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range items {
+		items[i].key = cgroupPrefix(v2) + items[i].key
+	}
+	return items, nil
+}
+
+// memoryKey is the cgroup controller setting which limits memory.
+var memoryKey = map[bool]string{false: "memory.limit_in_bytes", true: "memory.max"}
+
+// swapItems returns the cgroup controller settings which change the
+// memory-swap limit alone, given the memory limit already configured, in
+// bytes.
+func (r *Resources) swapItems(v2 bool, memory string) ([]lxcConfigItem, error) {
+	withMemory := *r
+	withMemory.Memory = memory
+	items, err := withMemory.cgroupItems(v2)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var swapItems []lxcConfigItem
+	for _, item := range items {
+		if item.key != memoryKey[v2] {
+			swapItems = append(swapItems, item)
+		}
+	}
+	return swapItems, nil
+}
+
+// memswKey is the cgroup v1 setting which limits memory and swap together,
+// and may not be less than the memory limit.
+const memswKey = "memory.memsw.limit_in_bytes"
+
+// keepSwapItems returns the cgroup v1 controller settings which change the
+// memory limit alone, given the memory and memory-swap limits already
+// configured, in bytes. The memory-swap limit is moved with the memory limit,
+// keeping the swap allowed unchanged, as it is with cgroup2.
+func (r *Resources) keepSwapItems(memory, memorySwap string) ([]lxcConfigItem, error) {
+	oldMemory, err := strconv.ParseInt(memory, 10, 64)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid memory limit")
+	}
+	oldMemorySwap, err := strconv.ParseInt(memorySwap, 10, 64)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid memory-swap limit")
+	}
+	newMemory, err := parseMemory(r.Memory)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	withSwap := *r
+	withSwap.MemorySwap = strconv.FormatInt(newMemory+oldMemorySwap-oldMemory, 10)
+	return withSwap.cgroupItems(false)
+}
+
+// orderMemoryItems orders cgroup v1 settings so that the memory limit never
+// exceeds the memory-swap limit while they are applied to a running
+// container, given its current memory-swap limit in bytes. Memory-swap is
+// raised before memory, and lowered after it.
+func orderMemoryItems(items []lxcConfigItem, currentMemorySwap string) []lxcConfigItem {
+	i := 0
+	for i < len(items) && items[i].key != memswKey {
+		i++
+	}
+	if i == 0 || i == len(items) {
+		return items
+	}
+	current, err := strconv.ParseInt(currentMemorySwap, 10, 64)
+	if err != nil {
+		return items
+	}
+	value, err := strconv.ParseInt(items[i].value, 10, 64)
+	if err != nil || value <= current {
+		return items
+	}
+	ordered := append([]lxcConfigItem{items[i]}, items[:i]...)
+	return append(ordered, items[i+1:]...)
+}
+
+// configValue returns the value of a config item, or "" if it is not set.
+func (c *Container) configValue(key string) string {
+	values := c.ConfigItem(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// SetResources changes the container's resource limits, applying them
+// immediately if it is running and saving them in its config. Only the
+// limits set in r are changed. Memory-swap may be changed alone if the
+// container has a memory limit. With cgroup v1, changing memory alone keeps
+// the swap allowed by an existing memory-swap limit.
+func (c *Container) SetResources(r *Resources) error {
+	v2 := cgroupV2()
+	var items []lxcConfigItem
+	var err error
+	memory := c.configValue(cgroupPrefix(v2) + memoryKey[v2])
+	memorySwap := ""
+	if !v2 {
+		memorySwap = c.configValue(cgroupPrefix(v2) + memswKey)
+	}
+	switch {
+	case r.MemorySwap != "" && r.Memory == "" && memory != "":
+		items, err = r.swapItems(v2, memory)
+	case r.Memory != "" && r.MemorySwap == "" && memory != "" && memorySwap != "":
+		items, err = r.keepSwapItems(memory, memorySwap)
+	default:
+		items, err = r.cgroupItems(v2)
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !v2 && c.Running() {
+		current := c.CgroupItem(memswKey)
+		if len(current) > 0 {
+			items = orderMemoryItems(items, current[0])
+		}
+	}
+	for _, item := range items {
+		if c.Running() {
+			err = c.SetCgroupItem(item.key, item.value)
+			if err != nil {
+				return errors.Annotatef(err, "cannot set %s=%q", item.key, item.value)
+			}
+		}
+		key := cgroupPrefix(v2) + item.key
+		err = c.ClearConfigItem(key)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = c.SetConfigItem(key, item.value)
+		if err != nil {
+			return errors.Annotatef(err, "key=%q value=%q", key, item.value)
+		}
+	}
+	return errors.Trace(c.SaveConfigFile(c.ConfigFileName()))
+}
+
+// resourceKeys are the cgroup controller settings which may be limited.
+var resourceKeys = map[bool][]string{
+	false: {"memory.limit_in_bytes", "memory.memsw.limit_in_bytes", "cpu.shares",
+		"cpu.cfs_period_us", "cpu.cfs_quota_us", "cpuset.cpus", "pids.max", "blkio.weight"},
+	true: {"memory.max", "memory.swap.max", "cpu.weight", "cpu.max", "cpuset.cpus", "pids.max", "io.weight"},
+}
+
+// CgroupLimits returns the container's cgroup limit settings, as currently
+// applied if it is running, or otherwise as configured. Settings which are not
+// configured are omitted.
+func (c *Container) CgroupLimits() map[string]string {
+	v2 := cgroupV2()
+	limits := make(map[string]string)
+	for _, key := range resourceKeys[v2] {
+		var values []string
+		if c.Running() {
+			values = c.CgroupItem(key)
+		} else {
+			values = c.ConfigItem(cgroupPrefix(v2) + key)
+		}
+		if len(values) > 0 && values[0] != "" {
+			limits[key] = strings.Join(values, " ")
+		}
+	}
+	return limits
+}
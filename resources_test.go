@@ -0,0 +1,108 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+type ResourcesSuite struct{}
+
+var _ = gc.Suite(&ResourcesSuite{})
+
+func (*ResourcesSuite) TestCgroupItems(c *gc.C) {
+	r := &Resources{Memory: "2G", MemorySwap: "3G", CPUShares: 2, CPUQuota: 1.5, Pids: 100}
+	items, err := r.cgroupItems(false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.DeepEquals, []lxcConfigItem{
+		{"memory.limit_in_bytes", "2147483648"},
+		{"memory.memsw.limit_in_bytes", "3221225472"},
+		{"cpu.shares", "2"},
+		{"cpu.cfs_period_us", "100000"},
+		{"cpu.cfs_quota_us", "150000"},
+		{"pids.max", "100"},
+	})
+	items, err = r.cgroupItems(true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.DeepEquals, []lxcConfigItem{
+		{"memory.max", "2147483648"},
+		{"memory.swap.max", "1073741824"},
+		{"cpu.weight", "1"},
+		{"cpu.max", "150000 100000"},
+		{"pids.max", "100"},
+	})
+}
+
+func (*ResourcesSuite) TestMinCPUQuota(c *gc.C) {
+	items, err := (&Resources{CPUQuota: 0.01}).cgroupItems(true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.DeepEquals, []lxcConfigItem{{"cpu.max", "1000 100000"}})
+	_, err = (&Resources{CPUQuota: 0.0001}).cgroupItems(true)
+	c.Assert(err, gc.ErrorMatches, "cpu-quota 0.0001 is less than the minimum of 0.01")
+}
+
+func (*ResourcesSuite) TestSwapItems(c *gc.C) {
+	r := &Resources{MemorySwap: "3G"}
+	items, err := r.swapItems(false, "2147483648")
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.DeepEquals, []lxcConfigItem{{"memory.memsw.limit_in_bytes", "3221225472"}})
+	items, err = r.swapItems(true, "2147483648")
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.DeepEquals, []lxcConfigItem{{"memory.swap.max", "1073741824"}})
+	_, err = r.swapItems(true, "4294967296")
+	c.Assert(err, gc.ErrorMatches, `memory-swap "3G" is less than memory "4294967296"`)
+}
+
+func (*ResourcesSuite) TestKeepSwapItems(c *gc.C) {
+	// 2G of memory with 1G of swap is raised to 4G of memory.
+	items, err := (&Resources{Memory: "4G"}).keepSwapItems("2147483648", "3221225472")
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.DeepEquals, []lxcConfigItem{
+		{"memory.limit_in_bytes", "4294967296"},
+		{"memory.memsw.limit_in_bytes", "5368709120"},
+	})
+	_, err = (&Resources{Memory: "4G"}).keepSwapItems("2147483648", "max")
+	c.Assert(err, gc.ErrorMatches, "invalid memory-swap limit: .*")
+}
+
+func (*ResourcesSuite) TestOrderMemoryItems(c *gc.C) {
+	raise := []lxcConfigItem{
+		{"memory.limit_in_bytes", "4294967296"},
+		{"memory.memsw.limit_in_bytes", "5368709120"},
+		{"pids.max", "100"},
+	}
+	// Memory-swap is raised first, so that memory never exceeds it.
+	c.Assert(orderMemoryItems(raise, "3221225472"), gc.DeepEquals, []lxcConfigItem{
+		{"memory.memsw.limit_in_bytes", "5368709120"},
+		{"memory.limit_in_bytes", "4294967296"},
+		{"pids.max", "100"},
+	})
+	c.Assert(raise[0].key, gc.Equals, "memory.limit_in_bytes")
+
+	// Memory is lowered first, for the same reason.
+	lower := []lxcConfigItem{
+		{"memory.limit_in_bytes", "1073741824"},
+		{"memory.memsw.limit_in_bytes", "2147483648"},
+	}
+	c.Assert(orderMemoryItems(lower, "3221225472"), gc.DeepEquals, lower)
+	c.Assert(orderMemoryItems(lower, "9223372036854771712"), gc.DeepEquals, lower)
+
+	memory := []lxcConfigItem{{"memory.limit_in_bytes", "1073741824"}}
+	c.Assert(orderMemoryItems(memory, "3221225472"), gc.DeepEquals, memory)
+	c.Assert(orderMemoryItems(raise, ""), gc.DeepEquals, raise)
+}
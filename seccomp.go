@@ -0,0 +1,178 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// SeccompPolicy describes the system calls denied to processes in a
+// container.
+type SeccompPolicy struct {
+	// Preset names a base set of denied system calls, one of "none",
+	// "default" or "browser". An empty preset is "default".
+	Preset string
+
+	// Allow removes system calls from the preset.
+	Allow []string
+
+	// Deny adds system calls to the preset.
+	Deny []string
+}
+
+var (
+	seccompDefault = []string{
+		// Kernel keyring
+		"add_key", "keyctl", "request_key",
+		// Process tracing
+		"ptrace", "process_vm_readv", "process_vm_writev",
+		// Kernel modules
+		"create_module", "init_module", "finit_module", "delete_module",
+		"kexec_load", "kexec_file_load",
+		// Filesystem handles, which can escape the container root
+		"open_by_handle_at",
+	}
+
+	// seccompBrowser further denies system calls a web browser has no need
+	// for. Mount calls are left alone, as install scripts run under the same
+	// policy.
+	seccompBrowser = append([]string{
+		"acct", "bpf", "lookup_dcookie", "perf_event_open", "quotactl",
+		"syslog", "userfaultfd", "uselib", "ustat", "sysfs", "_sysctl",
+		"swapon", "swapoff", "reboot", "pivot_root", "vhangup",
+		"settimeofday", "stime", "clock_settime", "clock_adjtime", "adjtimex",
+		"iopl", "ioperm", "name_to_handle_at", "nfsservctl",
+	}, seccompDefault...)
+
+	seccompPresets = map[string][]string{
+		"none":    nil,
+		"default": seccompDefault,
+		"browser": seccompBrowser,
+	}
+)
+
+var syscallPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func Seccomp(policy *SeccompPolicy) Option {
+	return func(c *Container) error {
+		if policy != nil {
+			if _, ok := seccompPresets[policy.preset()]; !ok {
+				return errors.Errorf("unknown seccomp preset %q", policy.Preset)
+			}
+			syscalls := append(append([]string(nil), policy.Allow...), policy.Deny...)
+			for _, syscall := range syscalls {
+				if !syscallPattern.MatchString(syscall) {
+					return errors.Errorf("invalid system call name %q", syscall)
+				}
+			}
+		}
+		c.seccomp = policy
+		return nil
+	}
+}
+
+// SeccompPresets returns the names of the seccomp presets, sorted.
+func SeccompPresets() []string {
+	var names []string
+	for name := range seccompPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *SeccompPolicy) preset() string {
+	if p.Preset == "" {
+		return "default"
+	}
+	return p.Preset
+}
+
+// checkArch checks that the system calls named by the policy exist on the
+// architecture.
+func (p *SeccompPolicy) checkArch(arch string) error {
+	known := archSyscalls(arch)
+	if known == nil {
+		return nil
+	}
+	syscalls := append(append([]string(nil), p.Allow...), p.Deny...)
+	for _, syscall := range syscalls {
+		if !known[syscall] {
+			return errors.Errorf("system call %q is not available on %s", syscall, arch)
+		}
+	}
+	return nil
+}
+
+// Denied returns the sorted system calls denied by the policy.
+func (p *SeccompPolicy) Denied() []string {
+	denied := make(map[string]bool)
+	for _, syscall := range seccompPresets[p.preset()] {
+		denied[syscall] = true
+	}
+	for _, syscall := range p.Deny {
+		denied[syscall] = true
+	}
+	for _, syscall := range p.Allow {
+		delete(denied, syscall)
+	}
+	var result []string
+	for syscall := range denied {
+		result = append(result, syscall)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// render returns the policy for the architecture in LXC's seccomp policy
+// format. Preset system calls which don't exist on the architecture are left
+// out, as LXC can't resolve them.
+func (p *SeccompPolicy) render(arch string) string {
+	known := archSyscalls(arch)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "2\nblacklist\n[all]\n")
+	for _, syscall := range p.Denied() {
+		if known == nil || known[syscall] {
+			fmt.Fprintf(&buf, "%s errno 1\n", syscall)
+		}
+	}
+	return buf.String()
+}
+
+// setupSeccomp renders the policy into the container directory, and
+// references it from the container config.
+func (c *Container) setupSeccomp() error {
+	f, err := os.OpenFile(path.Join(c.ConfigPath(), c.Name(), "seccomp.policy"),
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprint(f, c.seccomp.render(c.arch))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.SetConfigItem("lxc.seccomp", f.Name()))
+}
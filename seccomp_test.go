@@ -0,0 +1,74 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+type SeccompSuite struct{}
+
+var _ = gc.Suite(&SeccompSuite{})
+
+func (*SeccompSuite) TestRender(c *gc.C) {
+	policy := &SeccompPolicy{Preset: "browser", Allow: []string{"ptrace"}, Deny: []string{"mount"}}
+	amd64 := policy.render("amd64")
+	c.Assert(strings.HasPrefix(amd64, "2\nblacklist\n[all]\n"), gc.Equals, true)
+	c.Check(amd64, gc.Matches, `(?s).*\nmount errno 1\n.*`)
+	c.Check(amd64, gc.Matches, `(?s).*\niopl errno 1\n.*`)
+	c.Check(amd64, gc.Not(gc.Matches), `(?s).*\nptrace errno 1\n.*`)
+	c.Check(amd64, gc.Not(gc.Matches), `(?s).*\nstime errno 1\n.*`)
+
+	i386 := policy.render("i386")
+	c.Check(i386, gc.Matches, `(?s).*\nstime errno 1\n.*`)
+
+	arm64 := policy.render("arm64")
+	c.Check(arm64, gc.Not(gc.Matches), `(?s).*\niopl errno 1\n.*`)
+	c.Check(arm64, gc.Matches, `(?s).*\nmount errno 1\n.*`)
+
+	// Unknown architectures are rendered unfiltered.
+	c.Check(policy.render("riscv64"), gc.Matches, `(?s).*\niopl errno 1\n.*stime errno 1\n.*`)
+
+	c.Check((&SeccompPolicy{Preset: "none"}).render("amd64"), gc.Equals, "2\nblacklist\n[all]\n")
+}
+
+func (*SeccompSuite) TestCheckArch(c *gc.C) {
+	c.Check((&SeccompPolicy{Deny: []string{"mount", "iopl"}}).checkArch("amd64"), gc.IsNil)
+	c.Check((&SeccompPolicy{Deny: []string{"iopl"}}).checkArch("arm64"),
+		gc.ErrorMatches, `system call "iopl" is not available on arm64`)
+	c.Check((&SeccompPolicy{Allow: []string{"stime"}}).checkArch("amd64"),
+		gc.ErrorMatches, `system call "stime" is not available on amd64`)
+	c.Check((&SeccompPolicy{Deny: []string{"no_such_call"}}).checkArch("ppc64el"), gc.IsNil)
+
+	_, err := applyOptions([]Option{
+		Target("ubuntu", "trusty", "arm64"),
+		Seccomp(&SeccompPolicy{Deny: []string{"ioperm"}}),
+	})
+	c.Check(err, gc.ErrorMatches, `system call "ioperm" is not available on arm64`)
+}
+
+func (*SeccompSuite) TestOptionLeavesPolicy(c *gc.C) {
+	allow := make([]string, 1, 4)
+	allow[0] = "ptrace"
+	policy := &SeccompPolicy{Allow: allow, Deny: []string{"mount", "umount2"}}
+	err := CheckOptions(Seccomp(policy))
+	c.Assert(err, gc.IsNil)
+	c.Assert(allow[:cap(allow)], gc.DeepEquals, []string{"ptrace", "", "", ""})
+}
@@ -0,0 +1,155 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v1"
+)
+
+// SearchPathEnv names an environment variable holding directories, separated
+// by colons, which are searched for named templates before the defaults.
+const SearchPathEnv = "LXCIFY_TEMPLATE_PATH"
+
+// SearchPath returns the directories searched for named templates, in order:
+// those in $LXCIFY_TEMPLATE_PATH, $XDG_CONFIG_HOME/lxcify/templates, and
+// /usr/share/lxcify/templates.
+func SearchPath() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv(SearchPathEnv)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return append(dirs,
+		filepath.Join(configHome, "lxcify", "templates"),
+		"/usr/share/lxcify/templates")
+}
+
+var templateExts = []string{".yaml", ".yml"}
+
+// isTemplateName returns whether ref names a template in the search path,
+// rather than being a path to one.
+func isTemplateName(ref string) bool {
+	return !strings.ContainsRune(ref, filepath.Separator) && filepath.Ext(ref) == ""
+}
+
+// Find returns the path of a named template, from the first directory in the
+// search path which has it.
+func Find(name string) (string, error) {
+	if !isTemplateName(name) {
+		return "", errors.Errorf("invalid template name %q", name)
+	}
+	for _, dir := range SearchPath() {
+		for _, ext := range templateExts {
+			filename := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(filename); err == nil {
+				return filename, nil
+			}
+		}
+	}
+	return "", errors.NotFoundf("template %q in %s", name, strings.Join(SearchPath(), ":"))
+}
+
+// resolveRef returns the path of a template referred to by a path relative
+// to dir, or by name. Named templates are looked for in dir before the search
+// path, so that templates can extend their neighbours wherever they are.
+func resolveRef(ref, dir string) (string, error) {
+	if isTemplateName(ref) {
+		for _, ext := range templateExts {
+			filename := filepath.Join(dir, ref+ext)
+			if _, err := os.Stat(filename); err == nil {
+				return filename, nil
+			}
+		}
+		return Find(ref)
+	}
+	if !filepath.IsAbs(ref) {
+		ref = filepath.Join(dir, ref)
+	}
+	return ref, nil
+}
+
+// Resolve returns the path of a template given as a path, or by name.
+func Resolve(ref string) (string, error) {
+	return resolveRef(ref, ".")
+}
+
+// CatalogEntry describes a template in the search path.
+type CatalogEntry struct {
+	Name        string
+	Description string
+	Path        string
+}
+
+// Catalog returns the templates in the search path, sorted by name. Where
+// directories have templates of the same name, the first in the search path is
+// returned. Pod files are not templates, and are left out.
+func Catalog() ([]CatalogEntry, error) {
+	seen := make(map[string]bool)
+	var entries []CatalogEntry
+	for _, dir := range SearchPath() {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, fi := range files {
+			ext := filepath.Ext(fi.Name())
+			name := strings.TrimSuffix(fi.Name(), ext)
+			if fi.IsDir() || !contains(templateExts, ext) || seen[name] {
+				continue
+			}
+			filename := filepath.Join(dir, fi.Name())
+			in, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			m := make(map[interface{}]interface{})
+			if yaml.Unmarshal(in, &m) != nil {
+				// Broken templates are listed, to be found by validate.
+				m = nil
+			}
+			if _, ok := m["containers"]; ok {
+				continue
+			}
+			description, _ := m["description"].(string)
+			seen[name] = true
+			entries = append(entries, CatalogEntry{name, description, filename})
+		}
+	}
+	sort.Sort(catalogByName(entries))
+	return entries, nil
+}
+
+type catalogByName []CatalogEntry
+
+func (c catalogByName) Len() int           { return len(c) }
+func (c catalogByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+func (c catalogByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,101 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type CatalogSuite struct {
+	dirs          []string
+	oldPath       string
+	oldConfigHome string
+}
+
+var _ = gc.Suite(&CatalogSuite{})
+
+func (s *CatalogSuite) SetUpTest(c *gc.C) {
+	s.oldPath, s.oldConfigHome = os.Getenv(SearchPathEnv), os.Getenv("XDG_CONFIG_HOME")
+	s.dirs = []string{c.MkDir(), c.MkDir()}
+	os.Setenv(SearchPathEnv, s.dirs[0]+string(filepath.ListSeparator)+s.dirs[1])
+	os.Setenv("XDG_CONFIG_HOME", c.MkDir())
+
+	s.write(c, 0, "base.yaml", "description: Common desktop setup\ninstall-script: apt-get update\n")
+	s.write(c, 0, "tor-browser.yaml", "extends: base\ndescription: Tor Browser\nlaunch-command: tor\n")
+	s.write(c, 1, "tor-browser.yaml", "description: Shadowed\n")
+	s.write(c, 1, "chrome.yml", "extends: base\nlaunch-command: chrome\n")
+	s.write(c, 1, "pod.yaml", "containers: [{name: a, template: base}]\n")
+	s.write(c, 1, "README", "not a template")
+}
+
+func (s *CatalogSuite) TearDownTest(c *gc.C) {
+	os.Setenv(SearchPathEnv, s.oldPath)
+	os.Setenv("XDG_CONFIG_HOME", s.oldConfigHome)
+}
+
+func (s *CatalogSuite) write(c *gc.C, dir int, name, contents string) {
+	err := ioutil.WriteFile(filepath.Join(s.dirs[dir], name), []byte(contents), 0600)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *CatalogSuite) TestSearchPath(c *gc.C) {
+	path := SearchPath()
+	c.Assert(path, gc.HasLen, 4)
+	c.Assert(path[:2], gc.DeepEquals, s.dirs)
+	c.Assert(path[2], gc.Equals, filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "lxcify", "templates"))
+	c.Assert(path[3], gc.Equals, "/usr/share/lxcify/templates")
+}
+
+func (s *CatalogSuite) TestFind(c *gc.C) {
+	filename, err := Find("tor-browser")
+	c.Assert(err, gc.IsNil)
+	c.Assert(filename, gc.Equals, filepath.Join(s.dirs[0], "tor-browser.yaml"))
+
+	filename, err = Find("chrome")
+	c.Assert(err, gc.IsNil)
+	c.Assert(filename, gc.Equals, filepath.Join(s.dirs[1], "chrome.yml"))
+
+	_, err = Find("netscape")
+	c.Assert(err, gc.ErrorMatches, `template "netscape" in .* not found`)
+	_, err = Find("../base")
+	c.Assert(err, gc.ErrorMatches, `invalid template name "../base"`)
+}
+
+func (s *CatalogSuite) TestExtendsName(c *gc.C) {
+	filename, err := Find("tor-browser")
+	c.Assert(err, gc.IsNil)
+	t, err := ParseFile(filename)
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Description, gc.Equals, "Tor Browser")
+	c.Assert(t.InstallScript, gc.Equals, "apt-get update")
+	c.Assert(t.LaunchCommand, gc.Equals, "tor")
+}
+
+func (s *CatalogSuite) TestCatalog(c *gc.C) {
+	entries, err := Catalog()
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.DeepEquals, []CatalogEntry{
+		{"base", "Common desktop setup", filepath.Join(s.dirs[0], "base.yaml")},
+		{"chrome", "", filepath.Join(s.dirs[1], "chrome.yml")},
+		{"tor-browser", "Tor Browser", filepath.Join(s.dirs[0], "tor-browser.yaml")},
+	})
+}
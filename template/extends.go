@@ -0,0 +1,302 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v1"
+)
+
+// ParseFile parses a template file. A template may extend another with
+// `extends: <path>`, relative to the directory of the extending template, or
+// `extends: <name>`, found beside it or in the search path.
+// Sections are merged with those of the extended template: lists are
+// appended, with mounts of the same path replaced; install scripts run the
+// extended template's script first; and other fields are overridden. The
+// capabilities and seccomp lists are replaced rather than appended, so that
+// a template may loosen the policy it extends as well as tighten it, and
+// capabilities kept replace any dropped, or the reverse.
+func ParseFile(filename string, options ...ParseOption) (*Template, error) {
+	in, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return parse(in, filename, filepath.Dir(filename), newParseOptions(options))
+}
+
+func parse(in []byte, filename, dir string, o *parseOptions) (*Template, error) {
+	resolved, err := resolveExtends(in, filename, dir, nil, o)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	hostDirs := fileHostDirs(resolved)
+	out, err := yaml.Marshal(resolved)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var template Template
+	err = yaml.Unmarshal(out, &template)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Every template in the chain has been migrated.
+	template.SchemaVersion = SchemaVersion
+	err = template.expandParameters(o.values)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Host paths may be given by parameters, so are only resolved once
+	// they have been expanded.
+	for i, dir := range hostDirs {
+		host := template.Files[i].Host
+		if host != "" && !filepath.IsAbs(host) {
+			template.Files[i].Host = filepath.Join(dir, host)
+		}
+	}
+	return &template, nil
+}
+
+// resolveExtends parses a template into a map, merged with the chain of
+// templates it extends. chain holds the templates already extended, to
+// detect cycles. Each template is checked on its own, so that problems are
+// reported where they are written, and migrated to the current schema.
+func resolveExtends(in []byte, filename, dir string, chain []string, o *parseOptions) (map[interface{}]interface{}, error) {
+	annotate := func(err error) error {
+		if filename != "" {
+			return errors.Annotate(err, filename)
+		}
+		return errors.Trace(err)
+	}
+	doc, migrated, err := migrate(in)
+	m := make(map[interface{}]interface{})
+	if err == nil {
+		err = yaml.Unmarshal(in, &m)
+	}
+	if err != nil {
+		return nil, annotate(err)
+	}
+	// Keys are checked as written, before migration, so that positions
+	// refer to the template's own text.
+	err = o.checkKeys(in, filename, m, reflect.TypeOf(Template{}),
+		append([]string{"extends"}, templateRetiredKeys(m)...)...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if migrated {
+		in, err = yaml.Marshal(doc)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		m = make(map[interface{}]interface{})
+		err = yaml.Unmarshal(in, &m)
+	}
+	if err == nil {
+		// Decode into a template as well, to report type errors in the
+		// template which has them. yaml.v1 type errors have no positions.
+		var t Template
+		err = yaml.Unmarshal(in, &t)
+	}
+	if err != nil {
+		return nil, annotate(err)
+	}
+	recordFileHostDirs(m, dir)
+	// A firewall section without rules still denies by default.
+	if firewall, ok := m["firewall"]; ok && firewall == nil {
+		m["firewall"] = map[interface{}]interface{}{}
+	}
+	extends, ok := m["extends"]
+	if !ok {
+		return m, nil
+	}
+	delete(m, "extends")
+	ref, ok := extends.(string)
+	if !ok || ref == "" {
+		return nil, errors.Errorf("invalid extends %v, expected a template path or name", extends)
+	}
+	base, err := resolveRef(ref, dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	base, err = filepath.Abs(base)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, extended := range chain {
+		if extended == base {
+			return nil, errors.Errorf("extends cycle: %s -> %s", strings.Join(chain, " -> "), base)
+		}
+	}
+	baseIn, err := ioutil.ReadFile(base)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot read extended template")
+	}
+	parent, err := resolveExtends(baseIn, base, filepath.Dir(base), append(chain, base), o)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot extend %q", base)
+	}
+	return merge(parent, m, "").(map[interface{}]interface{}), nil
+}
+
+// hostDirKey keys the directory of the template naming a file, in the file's
+// map, which is removed by fileHostDirs before the map is decoded.
+type hostDirKey struct{}
+
+// recordFileHostDirs records the directory of the template naming each file,
+// against which a relative host path is resolved.
+func recordFileHostDirs(m map[interface{}]interface{}, dir string) {
+	files, _ := m["files"].([]interface{})
+	for _, f := range files {
+		if fm, ok := f.(map[interface{}]interface{}); ok {
+			fm[hostDirKey{}] = dir
+		}
+	}
+}
+
+// fileHostDirs removes and returns the directories recorded for files, in
+// order.
+func fileHostDirs(m map[interface{}]interface{}) []string {
+	files, _ := m["files"].([]interface{})
+	dirs := make([]string, len(files))
+	for i, f := range files {
+		if fm, ok := f.(map[interface{}]interface{}); ok {
+			dirs[i], _ = fm[hostDirKey{}].(string)
+			delete(fm, hostDirKey{})
+		}
+	}
+	return dirs
+}
+
+// replacedLists are the lists, by path, which a child template replaces
+// rather than appends to.
+var replacedLists = map[string]bool{
+	"capabilities.keep": true,
+	"capabilities.drop": true,
+	"seccomp.allow":     true,
+	"seccomp.deny":      true,
+}
+
+// merge merges a child template value into the value it extends. path is the
+// dotted path of the value's key in the template.
+func merge(parent, child interface{}, path string) interface{} {
+	switch c := child.(type) {
+	case map[interface{}]interface{}:
+		p, ok := parent.(map[interface{}]interface{})
+		if !ok {
+			return child
+		}
+		out := make(map[interface{}]interface{})
+		for k, v := range p {
+			out[k] = v
+		}
+		if path == "capabilities" {
+			// Keeping capabilities excludes dropping them, by list or
+			// preset, so whichever the child does replaces the other.
+			_, keep := c["keep"]
+			_, drop := c["drop"]
+			_, preset := c["preset"]
+			if keep {
+				delete(out, "drop")
+				delete(out, "preset")
+			} else if drop || preset {
+				delete(out, "keep")
+			}
+		}
+		for k, v := range c {
+			key := fmt.Sprint(k)
+			if path != "" {
+				key = path + "." + key
+			}
+			if pv, ok := out[k]; ok {
+				out[k] = merge(pv, v, key)
+			} else {
+				out[k] = v
+			}
+		}
+		return out
+	case []interface{}:
+		p, ok := parent.([]interface{})
+		if !ok || replacedLists[path] {
+			return child
+		}
+		if path == "mounts" {
+			return mergeMounts(p, c)
+		}
+		out := append([]interface{}(nil), p...)
+		for _, v := range c {
+			if !containsValue(out, v) {
+				out = append(out, v)
+			}
+		}
+		return out
+	case string:
+		if p, ok := parent.(string); ok && path == "install-script" {
+			if p != "" && !strings.HasSuffix(p, "\n") {
+				p += "\n"
+			}
+			return p + c
+		}
+	}
+	return child
+}
+
+// mergeMounts appends mounts, replacing any inherited mount of the same path
+// in the container.
+func mergeMounts(parent, child []interface{}) []interface{} {
+	var out []interface{}
+	for _, pm := range parent {
+		replaced := false
+		for _, cm := range child {
+			if mountTarget(pm) != "" && mountTarget(pm) == mountTarget(cm) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			out = append(out, pm)
+		}
+	}
+	return append(out, child...)
+}
+
+func mountTarget(v interface{}) string {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"passthru", "container"} {
+		if target, ok := m[key].(string); ok && target != "" {
+			return target
+		}
+	}
+	return ""
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type ExtendsSuite struct {
+	dir string
+}
+
+var _ = gc.Suite(&ExtendsSuite{})
+
+func (s *ExtendsSuite) SetUpTest(c *gc.C) {
+	s.dir = c.MkDir()
+	s.write(c, "base.yaml", `
+container:
+  template: ubuntu
+  distro: ubuntu
+  release: trusty
+  arch: amd64
+mounts:
+  - passthru: /dev/dri
+    directory: true
+  - passthru: /dev/snd
+    directory: true
+share-pulse-audio: true
+apparmor:
+  writable: [/home/ubuntu/Downloads]
+install-script: |
+  apt-get update
+launch-command: /bin/true
+`)
+	c.Assert(os.Mkdir(filepath.Join(s.dir, "browsers"), 0755), gc.IsNil)
+	s.write(c, "browsers/browser.yaml", `
+extends: ../base.yaml
+mounts:
+  - passthru: /dev/video0
+apparmor:
+  writable: [/home/ubuntu/Downloads, /home/ubuntu/.cache]
+files:
+  - host: policies.json
+    container: /etc/browser/policies.json
+install-script: apt-get install -y browser
+`)
+}
+
+func (s *ExtendsSuite) write(c *gc.C, name, contents string) string {
+	filename := filepath.Join(s.dir, name)
+	err := ioutil.WriteFile(filename, []byte(contents), 0600)
+	c.Assert(err, gc.IsNil)
+	return filename
+}
+
+func (s *ExtendsSuite) TestExtends(c *gc.C) {
+	filename := s.write(c, "browsers/firefox.yaml", `
+extends: browser.yaml
+container:
+  release: xenial
+mounts:
+  - host: /dev/video1
+    container: /dev/video0
+install-script: |
+  apt-get install -y firefox
+launch-command: firefox
+`)
+	t, err := ParseFile(filename)
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.ContainerInfo, gc.DeepEquals, container{
+		Template: "ubuntu", Distro: "ubuntu", Release: "xenial", Arch: "amd64",
+	})
+	c.Assert(t.Mounts, gc.DeepEquals, []mount{
+		{Passthru: "/dev/dri", IsDir: true},
+		{Passthru: "/dev/snd", IsDir: true},
+		{Host: "/dev/video1", Container: "/dev/video0"},
+	})
+	c.Assert(t.Audio, gc.DeepEquals, &audio{PulseAudio: true})
+	c.Assert(t.AppArmor.Writable, gc.DeepEquals, []string{"/home/ubuntu/Downloads", "/home/ubuntu/.cache"})
+	c.Assert(t.InstallScript, gc.Equals,
+		"apt-get update\napt-get install -y browser\napt-get install -y firefox\n")
+	c.Assert(t.LaunchCommand, gc.Equals, "firefox")
+	c.Assert(t.Files, gc.DeepEquals, []file{{
+		Host:      filepath.Join(s.dir, "browsers", "policies.json"),
+		Container: "/etc/browser/policies.json",
+	}})
+}
+
+func (s *ExtendsSuite) TestExtendsErrors(c *gc.C) {
+	testCases := []struct {
+		yaml       string
+		errPattern string
+	}{{
+		yaml:       "extends: missing.yaml",
+		errPattern: "cannot read extended template: .*",
+	}, {
+		yaml:       "extends: [base.yaml]",
+		errPattern: `invalid extends \[base.yaml\], expected a template path or name`,
+	}, {
+		yaml: "extends: typo.yaml",
+		errPattern: `cannot extend ".*/typo.yaml": .*/typo.yaml:2:1: ` +
+			`unknown key "launch-comand", did you mean "launch-command"\?`,
+	}, {
+		yaml:       "extends: cycle.yaml",
+		errPattern: `cannot extend ".*/cycle.yaml": extends cycle: .*/cycle.yaml -> .*/cycle.yaml`,
+	}}
+
+	s.write(c, "cycle.yaml", "extends: cycle.yaml")
+	s.write(c, "typo.yaml", "extends: base.yaml\nlaunch-comand: x\n")
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		_, err := ParseFile(s.write(c, "test.yaml", testCase.yaml))
+		c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+	}
+}
+
+func (s *ExtendsSuite) TestExtendsSecurityLists(c *gc.C) {
+	s.write(c, "hardened.yaml", `
+extends: base.yaml
+capabilities:
+  preset: hardened
+  drop: [net_raw]
+seccomp:
+  preset: browser
+  deny: [ptrace, mount]
+`)
+	t, err := ParseFile(s.write(c, "test.yaml", `
+extends: hardened.yaml
+capabilities:
+  keep: [chown, setuid, setgid]
+seccomp:
+  deny: [mount]
+`))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Capabilities, gc.DeepEquals, &capabilities{Keep: []string{"chown", "setuid", "setgid"}})
+	c.Assert(t.Seccomp, gc.DeepEquals, &seccomp{Preset: "browser", Deny: []string{"mount"}})
+	_, err = t.Container("foo")
+	c.Assert(err, gc.IsNil)
+
+	t, err = ParseFile(s.write(c, "test.yaml", `
+extends: hardened.yaml
+capabilities:
+  drop: [sys_admin]
+`))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Capabilities, gc.DeepEquals, &capabilities{Preset: "hardened", Drop: []string{"sys_admin"}})
+}
+
+func (s *ExtendsSuite) TestExtendsParameterizedFiles(c *gc.C) {
+	t, err := ParseFile(s.write(c, "browsers/firefox.yaml", `
+extends: browser.yaml
+parameters:
+  profile: {default: work}
+files:
+  - host: profiles/{{.profile}}
+    container: /home/ubuntu/.mozilla
+  - host: '{{.profile}}.js'
+    container: /home/ubuntu/prefs.js
+`))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Files, gc.HasLen, 3)
+	c.Assert(t.Files[0].Host, gc.Equals, filepath.Join(s.dir, "browsers", "policies.json"))
+	c.Assert(t.Files[1].Host, gc.Equals, filepath.Join(s.dir, "browsers", "profiles", "work"))
+	c.Assert(t.Files[2].Host, gc.Equals, filepath.Join(s.dir, "browsers", "work.js"))
+
+	t, err = ParseFile(filepath.Join(s.dir, "browsers", "firefox.yaml"),
+		Values(map[string]string{"profile": "/srv/profile"}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Files[2].Host, gc.Equals, "/srv/profile.js")
+}
@@ -0,0 +1,156 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"reflect"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v1"
+)
+
+// SchemaVersion is the current template schema version. Templates without a
+// schema-version key are version 1.
+const SchemaVersion = 2
+
+// migrations upgrade a template from the schema version they are keyed by to
+// the next.
+var migrations = map[int]func(yaml.MapSlice) (yaml.MapSlice, error){
+	1: migrateV1,
+}
+
+// Migrate rewrites a template written for an older schema version to the
+// current one, and reports whether it changed. Key order is kept, but
+// comments are not.
+func Migrate(in []byte) ([]byte, bool, error) {
+	doc, migrated, err := migrate(in)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	if version, _ := schemaVersion(doc); !migrated && version == SchemaVersion && hasKey(doc, "schema-version") {
+		return in, false, nil
+	}
+	out, err := yaml.Marshal(setKey(doc, "schema-version", SchemaVersion))
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	return out, true, nil
+}
+
+// migrate decodes a template and upgrades it to the current schema version.
+// It reports whether the upgrade changed anything but the version.
+func migrate(in []byte) (yaml.MapSlice, bool, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(in, &doc)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	version, err := schemaVersion(doc)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	orig := append(yaml.MapSlice(nil), doc...)
+	for ; version < SchemaVersion; version++ {
+		doc, err = migrations[version](doc)
+		if err != nil {
+			return nil, false, errors.Annotatef(err, "cannot migrate from schema-version %d", version)
+		}
+	}
+	return doc, !reflect.DeepEqual(orig, doc), nil
+}
+
+// retiredKeys lists, by schema version, the top-level keys which the
+// migration from that version replaces.
+var retiredKeys = map[int][]string{
+	1: {"share-pulse-audio"},
+}
+
+// templateRetiredKeys returns the keys which a decoded template may use
+// because its schema version predates their replacement.
+func templateRetiredKeys(m map[interface{}]interface{}) []string {
+	version := 1
+	if v, ok := m["schema-version"].(int); ok {
+		version = v
+	}
+	var keys []string
+	for ; version < SchemaVersion; version++ {
+		keys = append(keys, retiredKeys[version]...)
+	}
+	return keys
+}
+
+// schemaVersion returns the schema version a template declares.
+func schemaVersion(doc yaml.MapSlice) (int, error) {
+	for _, item := range doc {
+		if item.Key != "schema-version" {
+			continue
+		}
+		version, ok := item.Value.(int)
+		if !ok || version < 1 || version > SchemaVersion {
+			return 0, errors.Errorf("unsupported schema-version %v, expected 1 to %d", item.Value, SchemaVersion)
+		}
+		return version, nil
+	}
+	return 1, nil
+}
+
+// migrateV1 replaces share-pulse-audio with the audio section.
+func migrateV1(doc yaml.MapSlice) (yaml.MapSlice, error) {
+	var out yaml.MapSlice
+	for _, item := range doc {
+		if item.Key != "share-pulse-audio" {
+			out = append(out, item)
+			continue
+		}
+		share, ok := item.Value.(bool)
+		if !ok {
+			return nil, errors.Errorf("invalid share-pulse-audio %v, expected true or false", item.Value)
+		}
+		if share {
+			// Not sharing audio is the default, which audio may override.
+			if hasKey(doc, "audio") {
+				return nil, errors.New("share-pulse-audio conflicts with audio")
+			}
+			out = append(out, yaml.MapItem{
+				Key:   "audio",
+				Value: yaml.MapSlice{{Key: "pulseaudio", Value: true}},
+			})
+		}
+	}
+	return out, nil
+}
+
+func hasKey(doc yaml.MapSlice, key string) bool {
+	for _, item := range doc {
+		if item.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// setKey sets a key in place, or adds it first.
+func setKey(doc yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(yaml.MapSlice{{Key: key, Value: value}}, doc...)
+}
@@ -0,0 +1,98 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+type MigrateSuite struct{}
+
+var _ = gc.Suite(&MigrateSuite{})
+
+const v1Yaml = `container:
+  template: ubuntu
+share-pulse-audio: true
+install-script: apt-get install -y beef
+launch-command: beef
+`
+
+func (*MigrateSuite) TestMigrate(c *gc.C) {
+	out, changed, err := Migrate([]byte(v1Yaml))
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, gc.Equals, true)
+	c.Assert(string(out), gc.Equals, `schema-version: 2
+container:
+  template: ubuntu
+audio:
+  pulseaudio: true
+install-script: apt-get install -y beef
+launch-command: beef
+`)
+
+	again, changed, err := Migrate(out)
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, gc.Equals, false)
+	c.Assert(string(again), gc.Equals, string(out))
+
+	t, err := Parse([]byte(v1Yaml))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.SchemaVersion, gc.Equals, SchemaVersion)
+	c.Assert(t.Audio, gc.DeepEquals, &audio{PulseAudio: true})
+}
+
+func (*MigrateSuite) TestMigrateNoAudio(c *gc.C) {
+	out, changed, err := Migrate([]byte("share-pulse-audio: false\naudio:\n  pulseaudio: true\n"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, gc.Equals, true)
+	c.Assert(string(out), gc.Equals, "schema-version: 2\naudio:\n  pulseaudio: true\n")
+}
+
+func (*MigrateSuite) TestMigrateErrors(c *gc.C) {
+	testCases := []struct {
+		yaml       string
+		errPattern string
+	}{{
+		yaml:       "schema-version: 3\n",
+		errPattern: `unsupported schema-version 3, expected 1 to 2`,
+	}, {
+		yaml:       "schema-version: two\n",
+		errPattern: `unsupported schema-version two, expected 1 to 2`,
+	}, {
+		yaml:       "share-pulse-audio: loud\n",
+		errPattern: `cannot migrate from schema-version 1: invalid share-pulse-audio loud, expected true or false`,
+	}, {
+		yaml:       "schema-version: 1\nshare-pulse-audio: true\naudio:\n  pulseaudio: true\n",
+		errPattern: `cannot migrate from schema-version 1: share-pulse-audio conflicts with audio`,
+	}, {
+		yaml:       "schema-version: 2\nshare-pulse-audio: true\n",
+		errPattern: `line 2, column 1: unknown key "share-pulse-audio".*`,
+	}, {
+		// Positions in older templates refer to the template as written.
+		yaml:       "# Beef.\nshare-pulse-audio: true\n\nlaunch-comand: beef\n",
+		errPattern: `line 4, column 1: unknown key "launch-comand", did you mean "launch-command"\?`,
+	}, {
+		yaml:       "share-pulse-audio: true\ncontainer: {template: ubuntu, relase: xenial}\n",
+		errPattern: `line 2, column 31: unknown key "relase" in container, did you mean "release"\?`,
+	}}
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		_, err := Parse([]byte(testCase.yaml))
+		c.Check(err, gc.ErrorMatches, testCase.errPattern)
+	}
+}
@@ -0,0 +1,180 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+	texttemplate "text/template"
+
+	"github.com/juju/errors"
+
+	"github.com/cmars/lxcify"
+)
+
+// parameter declares a value which may be substituted into a template.
+type parameter struct {
+	// Type is string, int or bool. Values are checked against it, and
+	// converted so that templates can use them in conditions.
+	Type string `yaml:"type,omitempty"`
+
+	// Default is used when no value is given. Parameters without a default
+	// are required.
+	Default *string `yaml:"default,omitempty"`
+
+	Description string `yaml:"description,omitempty"`
+}
+
+var parameterNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Values sets template parameters, overriding their defaults.
+func Values(values map[string]string) ParseOption {
+	return func(o *parseOptions) {
+		if o.values == nil {
+			o.values = make(map[string]string)
+		}
+		for k, v := range values {
+			o.values[k] = v
+		}
+	}
+}
+
+func (p parameter) convert(name, value string) (interface{}, error) {
+	switch p.Type {
+	case "", "string":
+		return value, nil
+	case "int":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Errorf("parameter %q must be an int, got %q", name, value)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.Errorf("parameter %q must be a bool, got %q", name, value)
+		}
+		return b, nil
+	}
+	return nil, errors.Errorf("parameter %q has unknown type %q", name, p.Type)
+}
+
+// parameterValues returns the values of the template's parameters, from
+// values or their defaults.
+func (t *Template) parameterValues(values map[string]string) (map[string]interface{}, error) {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := t.Parameters[name]; !ok {
+			return nil, errors.Errorf("unknown parameter %q", name)
+		}
+	}
+
+	data := make(map[string]interface{})
+	for name, p := range t.Parameters {
+		if !parameterNamePattern.MatchString(name) {
+			return nil, errors.Errorf("invalid parameter name %q", name)
+		}
+		value, ok := values[name]
+		if !ok {
+			if p.Default == nil {
+				return nil, errors.Errorf("parameter %q is required", name)
+			}
+			value = *p.Default
+		}
+		converted, err := p.convert(name, value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		data[name] = converted
+	}
+	return data, nil
+}
+
+// parameterFuncs are the functions available when expanding parameters.
+// Values substituted into the install script or launch command should be
+// quoted with shellquote, as in {{shellquote .name}}.
+var parameterFuncs = texttemplate.FuncMap{
+	"shellquote": lxcify.ShellQuote,
+}
+
+// expandParameters substitutes parameter values, written as {{.name}}, into
+// the install script, launch command, mounts and desktop launcher. Templates
+// without parameters are left as they are; in those with parameters, a
+// literal "{{" is written {{"{{"}}.
+func (t *Template) expandParameters(values map[string]string) error {
+	if len(t.Parameters) == 0 && len(values) == 0 {
+		return nil
+	}
+	data, err := t.parameterValues(values)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	expand := func(field string, s *string) {
+		if err != nil || !bytes.Contains([]byte(*s), []byte("{{")) {
+			return
+		}
+		var tmpl *texttemplate.Template
+		tmpl, err = texttemplate.New(field).Funcs(parameterFuncs).Option("missingkey=error").Parse(*s)
+		if err != nil {
+			err = errors.Annotatef(err, "cannot expand %s", field)
+			return
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, data)
+		if err != nil {
+			err = errors.Annotatef(err, "cannot expand %s", field)
+			return
+		}
+		*s = buf.String()
+	}
+
+	expand("install-script", &t.InstallScript)
+	expand("launch-command", &t.LaunchCommand)
+	for i := range t.Packages {
+		expand("packages", &t.Packages[i])
+	}
+	for i := range t.Downloads {
+		expand("downloads", &t.Downloads[i].URL)
+		expand("downloads", &t.Downloads[i].SHA256)
+		expand("downloads", &t.Downloads[i].Path)
+	}
+	for i := range t.Files {
+		expand("files", &t.Files[i].Host)
+		expand("files", &t.Files[i].Container)
+	}
+	for i := range t.Mounts {
+		expand("mounts", &t.Mounts[i].Passthru)
+		expand("mounts", &t.Mounts[i].Host)
+		expand("mounts", &t.Mounts[i].Container)
+	}
+	if dl := t.DesktopLauncher; dl != nil {
+		expand("desktop-launcher.name", &dl.Name)
+		expand("desktop-launcher.comment", &dl.Comment)
+		expand("desktop-launcher.icon-path", &dl.IconPath)
+		for i := range dl.Categories {
+			expand("desktop-launcher.categories", &dl.Categories[i])
+		}
+	}
+	return err
+}
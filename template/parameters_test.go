@@ -0,0 +1,135 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+type ParametersSuite struct{}
+
+var _ = gc.Suite(&ParametersSuite{})
+
+const parametersYaml = `
+parameters:
+  profile:
+    description: Firefox profile name
+    default: work
+  downloads:
+    description: Host directory for downloads
+  private:
+    type: bool
+    default: "false"
+mounts:
+  - host: "{{.downloads}}"
+    container: /home/ubuntu/Downloads
+    directory: true
+install-script: apt-get install -y firefox
+launch-command: firefox -P {{.profile}}{{if .private}} -private{{end}}
+desktop-launcher:
+  name: Firefox ({{.profile}})
+`
+
+func (*ParametersSuite) TestParameters(c *gc.C) {
+	t, err := Parse([]byte(parametersYaml), Values(map[string]string{
+		"downloads": "/home/me/Downloads/banking",
+		"profile":   "banking",
+		"private":   "true",
+	}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.LaunchCommand, gc.Equals, "firefox -P banking -private")
+	c.Assert(t.Mounts[0].Host, gc.Equals, "/home/me/Downloads/banking")
+	c.Assert(t.DesktopLauncher.Name, gc.Equals, "Firefox (banking)")
+
+	t, err = Parse([]byte(parametersYaml), Values(map[string]string{"downloads": "/tmp"}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.LaunchCommand, gc.Equals, "firefox -P work")
+}
+
+func (*ParametersSuite) TestTypedDefaults(c *gc.C) {
+	t, err := Parse([]byte(`
+parameters:
+  count: {type: int, default: 3}
+  private: {type: bool, default: true}
+launch-command: run {{.count}}{{if .private}} private{{end}}
+`))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.LaunchCommand, gc.Equals, "run 3 private")
+}
+
+func (*ParametersSuite) TestShellQuote(c *gc.C) {
+	t, err := Parse([]byte(`
+parameters:
+  profile: {default: work}
+install-script: |
+  echo {{"{{"}}profile}} >/tmp/{{shellquote .profile}}
+launch-command: firefox -P {{shellquote .profile}}
+`), Values(map[string]string{"profile": "it's mine; rm -rf ~"}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.InstallScript, gc.Equals, `echo {{profile}} >/tmp/'it'\''s mine; rm -rf ~'`+"\n")
+	c.Assert(t.LaunchCommand, gc.Equals, `firefox -P 'it'\''s mine; rm -rf ~'`)
+}
+
+func (*ParametersSuite) TestExamples(c *gc.C) {
+	t, err := ParseFile("../examples/firefox-flash.yaml", Values(map[string]string{
+		"homepage": "https://example.com/?a=1&b=2",
+	}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.LaunchCommand, gc.Equals, `firefox -private 'https://example.com/?a=1&b=2' $*`)
+}
+
+func (*ParametersSuite) TestParameterErrors(c *gc.C) {
+	testCases := []struct {
+		yaml       string
+		values     map[string]string
+		errPattern string
+	}{{
+		yaml:       parametersYaml,
+		errPattern: `parameter "downloads" is required`,
+	}, {
+		yaml:       parametersYaml,
+		values:     map[string]string{"downloads": "/tmp", "private": "maybe"},
+		errPattern: `parameter "private" must be a bool, got "maybe"`,
+	}, {
+		yaml:       parametersYaml,
+		values:     map[string]string{"downloads": "/tmp", "profiel": "x"},
+		errPattern: `unknown parameter "profiel"`,
+	}, {
+		yaml:       "launch-command: firefox",
+		values:     map[string]string{"profile": "x"},
+		errPattern: `unknown parameter "profile"`,
+	}, {
+		yaml:       "{parameters: {count: {default: '1'}}, launch-command: 'echo {{.m}}'}",
+		errPattern: `cannot expand launch-command: .*map has no entry for key "m"`,
+	}, {
+		yaml:       "{parameters: {count: {type: float, default: '1'}}}",
+		errPattern: `parameter "count" has unknown type "float"`,
+	}, {
+		yaml:       "{parameters: {n-1: {default: '1'}}}",
+		errPattern: `invalid parameter name "n-1"`,
+	}, {
+		yaml:       "{parameters: {count: {defualt: '1'}}}",
+		errPattern: `line 1, column 23: unknown key "defualt" in parameters.count, did you mean "default"\?`,
+	}}
+
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		_, err := Parse([]byte(testCase.yaml), Values(testCase.values))
+		c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+	}
+}
@@ -0,0 +1,213 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v1"
+)
+
+// Pod describes several containers which are brought up and down together.
+type Pod struct {
+	// Link is a bridge all of the pod's containers are attached to, so that
+	// they can reach each other. Containers in the default network mode are
+	// switched to NAT mode on it, and containers without a network are left
+	// alone.
+	Link       string         `yaml:"link,omitempty"`
+	Containers []podContainer `yaml:"containers"`
+
+	dir     string
+	options []ParseOption
+}
+
+type podContainer struct {
+	Name string `yaml:"name"`
+
+	// Template is the path of the container's template, relative to the
+	// pod file, or the name of a template in the search path.
+	Template string `yaml:"template"`
+
+	// DependsOn names containers which must be running before this one
+	// starts.
+	DependsOn []string `yaml:"depends-on,omitempty"`
+
+	// Links names containers whose addresses are added to this one's
+	// /etc/hosts. Linked containers are also dependencies.
+	Links []string `yaml:"links,omitempty"`
+
+	// Values sets the template's parameters.
+	Values map[string]string `yaml:"values,omitempty"`
+}
+
+// ParsePod parses a pod. Member templates are relative to the current
+// directory. Parse options also apply to member templates.
+func ParsePod(in []byte, options ...ParseOption) (*Pod, error) {
+	return parsePod(in, "", options)
+}
+
+func parsePod(in []byte, filename string, options []ParseOption) (*Pod, error) {
+	pod := Pod{options: options}
+	err := yaml.Unmarshal(in, &pod)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	m := make(map[interface{}]interface{})
+	err = yaml.Unmarshal(in, &m)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	err = newParseOptions(options).checkKeys(in, filename, m, reflect.TypeOf(pod))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	_, err = pod.Order()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &pod, nil
+}
+
+// ParsePodFile parses a pod file. Member templates are relative to the
+// directory containing it.
+func ParsePodFile(filename string, options ...ParseOption) (*Pod, error) {
+	in, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pod, err := parsePod(in, filename, options)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid pod %q", filename)
+	}
+	pod.dir = filepath.Dir(filename)
+	return pod, nil
+}
+
+func (pc *podContainer) dependencies() []string {
+	return append(append([]string(nil), pc.DependsOn...), pc.Links...)
+}
+
+// Order returns the names of the pod's containers in the order they must be
+// started, dependencies first.
+func (p *Pod) Order() ([]string, error) {
+	if len(p.Containers) == 0 {
+		return nil, errors.New("pod has no containers")
+	}
+	byName := make(map[string]*podContainer)
+	for i := range p.Containers {
+		pc := &p.Containers[i]
+		if pc.Name == "" {
+			return nil, errors.New("pod container missing name")
+		}
+		if pc.Template == "" {
+			return nil, errors.Errorf("pod container %q missing template", pc.Name)
+		}
+		if _, ok := byName[pc.Name]; ok {
+			return nil, errors.Errorf("duplicate pod container %q", pc.Name)
+		}
+		byName[pc.Name] = pc
+	}
+
+	var order []string
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return errors.Errorf("dependency cycle at pod container %q", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		deps := byName[name].dependencies()
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := byName[dep]; !ok {
+				return errors.Errorf("pod container %q depends on unknown container %q", name, dep)
+			}
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+	// Visit in declaration order, so that independent containers start in
+	// the order they are written.
+	for _, pc := range p.Containers {
+		err := visit(pc.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return order, nil
+}
+
+func (p *Pod) container(name string) (*podContainer, error) {
+	for i := range p.Containers {
+		if p.Containers[i].Name == name {
+			return &p.Containers[i], nil
+		}
+	}
+	return nil, errors.NotFoundf("pod container %q", name)
+}
+
+// Template returns the template of a container in the pod, with the pod's
+// networking applied.
+func (p *Pod) Template(name string) (*Template, error) {
+	pc, err := p.container(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	filename, err := resolveRef(pc.Template, p.dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	options := append([]ParseOption{Values(pc.Values)}, p.options...)
+	t, err := ParseFile(filename, options...)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid template %q", filename)
+	}
+	if p.Link != "" && t.Network.Link == "" && t.Network.Mode != "none" {
+		if t.Network.Mode == "" {
+			t.Network.Mode = "nat"
+		}
+		t.Network.Link = p.Link
+	}
+	return t, nil
+}
+
+// Links returns the names of the containers linked to a container in the
+// pod.
+func (p *Pod) Links(name string) ([]string, error) {
+	pc, err := p.container(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return pc.Links, nil
+}
@@ -0,0 +1,117 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type PodSuite struct{}
+
+var _ = gc.Suite(&PodSuite{})
+
+func (*PodSuite) TestOrder(c *gc.C) {
+	testCases := []struct {
+		yaml       string
+		order      []string
+		errPattern string
+	}{{
+		yaml: `
+containers:
+  - {name: browser, template: browser.yaml, links: [gateway]}
+  - {name: gateway, template: gateway.yaml}
+`,
+		order: []string{"gateway", "browser"},
+	}, {
+		yaml: `
+containers:
+  - {name: app, template: app.yaml, depends-on: [db, cache]}
+  - {name: cache, template: cache.yaml}
+  - {name: db, template: db.yaml, depends-on: [cache]}
+  - {name: other, template: other.yaml}
+`,
+		order: []string{"cache", "db", "app", "other"},
+	}, {
+		yaml:       "containers: []",
+		errPattern: "pod has no containers",
+	}, {
+		yaml:       "containers: [{name: a, template: a.yaml}, {name: a, template: b.yaml}]",
+		errPattern: `duplicate pod container "a"`,
+	}, {
+		yaml:       "containers: [{name: a}]",
+		errPattern: `pod container "a" missing template`,
+	}, {
+		yaml:       "containers: [{name: a, template: a.yaml, depends-on: [b]}]",
+		errPattern: `pod container "a" depends on unknown container "b"`,
+	}, {
+		yaml: `
+containers:
+  - {name: a, template: a.yaml, depends-on: [b]}
+  - {name: b, template: b.yaml, links: [a]}
+`,
+		errPattern: `dependency cycle at pod container "a"`,
+	}}
+
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		pod, err := ParsePod([]byte(testCase.yaml))
+		if testCase.errPattern == "" {
+			c.Assert(err, gc.IsNil)
+			order, err := pod.Order()
+			c.Assert(err, gc.IsNil)
+			c.Assert(order, gc.DeepEquals, testCase.order)
+		} else {
+			c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+		}
+	}
+}
+
+func (*PodSuite) TestTemplate(c *gc.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "gateway.yaml"), []byte("network: {mode: host-only}"), 0600)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "browser.yaml"), []byte("{parameters: {profile: {}}, launch-command: 'firefox -P {{.profile}}'}"), 0600)
+	c.Assert(err, gc.IsNil)
+	podFile := filepath.Join(dir, "pod.yaml")
+	err = ioutil.WriteFile(podFile, []byte(`
+link: lxcifybr1
+containers:
+  - {name: browser, template: browser.yaml, links: [gateway], values: {profile: work}}
+  - {name: gateway, template: gateway.yaml}
+`), 0600)
+	c.Assert(err, gc.IsNil)
+
+	pod, err := ParsePodFile(podFile)
+	c.Assert(err, gc.IsNil)
+	t, err := pod.Template("browser")
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.LaunchCommand, gc.Equals, "firefox -P work")
+	c.Assert(t.Network, gc.DeepEquals, network{Mode: "nat", Link: "lxcifybr1"})
+	t, err = pod.Template("gateway")
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Network, gc.DeepEquals, network{Mode: "host-only", Link: "lxcifybr1"})
+	links, err := pod.Links("browser")
+	c.Assert(err, gc.IsNil)
+	c.Assert(links, gc.DeepEquals, []string{"gateway"})
+
+	_, err = pod.Template("nope")
+	c.Assert(err, gc.ErrorMatches, `pod container "nope" not found`)
+}
@@ -0,0 +1,237 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/cmars/lxcify"
+)
+
+// jsonSchema is a JSON Schema (draft-07) node.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	PropertyNames        *jsonSchema            `json:"propertyNames,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+}
+
+// fieldDescriptions describes each template key, by its dotted path.
+// Sequences and maps don't add to the path.
+var fieldDescriptions = map[string]string{
+	"extends":                      "Template extended by this one, as a path relative to this template or a name in the template search path.",
+	"schema-version":               "Template schema version. Templates without one are version 1, and are migrated when parsed.",
+	"description":                  "What the template is for, shown by lxcify templates.",
+	"parameters":                   "Parameters which may be substituted into the template, by name, as {{.name}}. Quote values used in the install script or launch command with {{shellquote .name}}. A literal {{ is written {{\"{{\"}}.",
+	"parameters.type":              "Parameter type. Values are checked against it.",
+	"parameters.default":           "Value used when none is given. Parameters without a default are required.",
+	"parameters.description":       "What the parameter is for.",
+	"container":                    "LXC template used to create the container.",
+	"container.template":           "LXC template name, such as ubuntu or download.",
+	"container.distro":             "Distribution to install.",
+	"container.release":            "Distribution release to install.",
+	"container.arch":               "Architecture to install.",
+	"mounts":                       "Host devices and files mounted into the container.",
+	"mounts.passthru":              "Host path mounted at the same path in the container. Exclusive with host and container.",
+	"mounts.host":                  "Host path to mount.",
+	"mounts.container":             "Absolute path in the container to mount the host path at.",
+	"mounts.directory":             "Whether the mount is a directory.",
+	"audio":                        "Sound shared with the host.",
+	"audio.pulseaudio":             "Share the host PulseAudio server with the container.",
+	"gpu":                          "GPU driver. The default relies on mounts such as /dev/dri, which suffice for Mesa.",
+	"apparmor":                     "AppArmor profile generated for the container.",
+	"apparmor.writable":            "Container paths the app may write to.",
+	"apparmor.complain":            "Log denials rather than enforce them.",
+	"seccomp":                      "System calls denied to processes in the container.",
+	"seccomp.preset":               "Base set of denied system calls, default if unset.",
+	"seccomp.allow":                "System calls removed from the preset. Replaces the list of an extended template.",
+	"seccomp.deny":                 "System calls added to the preset. Replaces the list of an extended template.",
+	"capabilities":                 "Capabilities dropped from, or kept by, the container root user.",
+	"capabilities.preset":          "Base set of dropped capabilities, hardened unless keep is given. none drops nothing.",
+	"capabilities.drop":            "Capabilities to drop. Exclusive with keep. Replaces the list of an extended template.",
+	"capabilities.keep":            "The only capabilities to keep. Exclusive with drop and preset. Replaces the list of an extended template.",
+	"network":                      "How the container is connected to the network.",
+	"network.mode":                 "Network mode. The LXC default configuration is used if unset.",
+	"network.link":                 "Bridge to attach the container to, required in host-only mode.",
+	"network.proxy":                "Proxy URL, such as socks5://10.0.3.1:1080, required in proxy mode. Other traffic is dropped.",
+	"network.limits":               "Bandwidth limits on the container's network interface.",
+	"network.limits.egress":        "Limit on traffic sent by the container.",
+	"network.limits.egress.rate":   "Rate, in tc units such as 1mbit.",
+	"network.limits.egress.burst":  "Burst size, in tc units such as 64kb.",
+	"network.limits.ingress":       "Limit on traffic received by the container.",
+	"network.limits.ingress.rate":  "Rate, in tc units such as 1mbit.",
+	"network.limits.ingress.burst": "Burst size, in tc units such as 64kb.",
+	"firewall":                     "Egress firewall. Only the listed destinations, and the bridge DHCP and DNS service, are reachable, even if none are listed.",
+	"firewall.allow":               "Destinations the container may connect to.",
+	"firewall.allow.destination":   "Destination address, network or hostname.",
+	"firewall.allow.ports":         "Destination ports. All ports are allowed if unset.",
+	"dns":                          "How the container resolves names.",
+	"dns.mode":                     "DNS mode. The LXC bridge resolver is used if unset.",
+	"dns.nameservers":              "Nameserver IPv4 addresses, for custom and tls modes.",
+	"identity":                     "Whether the container hostname, MAC address and machine-id persist across starts.",
+	"ports":                        "Host ports on 127.0.0.1 forwarded to the container.",
+	"ports.host":                   "Host port.",
+	"ports.container":              "Container port.",
+	"vpn":                          "VPN all container traffic is routed through. Firewall allow rules are not supported with a VPN.",
+	"vpn.type":                     "VPN client.",
+	"vpn.config":                   "Host path of the VPN client configuration.",
+	"resources":                    "Resource limits on the container.",
+	"resources.memory":             "Memory limit, such as 2G.",
+	"resources.memory-swap":        "Memory plus swap limit, such as 4G.",
+	"resources.cpu-shares":         "Relative CPU weight.",
+	"resources.cpu-quota":          "CPU time limit, in CPUs, of at least 0.01.",
+	"resources.cpuset":             "CPUs the container may run on, such as 0-3.",
+	"resources.pids":               "Process limit.",
+	"resources.blkio-weight":       "Relative block I/O weight, 10 to 1000.",
+	"repositories":                 "Apt repositories added before packages are installed.",
+	"repositories.name":            "Name of the repository's sources list and keyring.",
+	"repositories.url":             "Repository base URL.",
+	"repositories.suite":           "Distribution suite, such as stable.",
+	"repositories.components":      "Repository components, such as main.",
+	"repositories.key":             "URL of the key the repository is signed with, which must be https unless key-sha256 is given.",
+	"repositories.key-sha256":      "Hex SHA-256 of the key.",
+	"packages":                     "Packages installed with the distro package manager, before the install script runs.",
+	"downloads":                    "Files fetched and verified on the host, then copied into the container after packages are installed.",
+	"downloads.url":                "URL to fetch. A file:/// URL names a file on the host, for offline installs.",
+	"downloads.sha256":             "Hex SHA-256 the file must have.",
+	"downloads.path":               "Absolute path of the file in the container.",
+	"downloads.mode":               "Octal permissions, such as 0755, of the file in the container. 0644 if unset.",
+	"files":                        "Host files and directories copied into the container after downloads.",
+	"files.host":                   "Host file or directory to copy. Relative paths are relative to the template.",
+	"files.container":              "Absolute path in the container to copy it to.",
+	"files.mode":                   "Octal permissions, such as 0644, for the file or each file in the directory. Host permissions are kept if unset.",
+	"files.owner":                  "User, or user:group, to own the copy. Root owns it if unset.",
+	"install-script":               "Script run in the container to install the app, after packages, downloads and files. Appended to the script of an extended template.",
+	"launch-command":               "Command run in the container to launch the app.",
+	"desktop-launcher":             "Desktop launcher installed on the host.",
+	"desktop-launcher.name":        "Launcher name.",
+	"desktop-launcher.comment":     "Launcher tooltip.",
+	"desktop-launcher.icon-path":   "Host path of the launcher icon.",
+	"desktop-launcher.categories":  "Menu categories, in addition to LXCify.",
+}
+
+// fieldEnums lists the values of keys which take one of a fixed set.
+var fieldEnums = map[string][]string{
+	"parameters.type":     {"string", "int", "bool"},
+	"container.arch":      knownArchs,
+	"gpu":                 {string(lxcify.NvidiaGPU)},
+	"seccomp.preset":      lxcify.SeccompPresets(),
+	"capabilities.preset": lxcify.CapabilityPresets(),
+	"network.mode": {
+		string(lxcify.NetworkNone), string(lxcify.NetworkNAT), string(lxcify.NetworkHostOnly),
+		string(lxcify.NetworkProxy), string(lxcify.NetworkTor),
+	},
+	"dns.mode": {
+		string(lxcify.DNSCustom), string(lxcify.DNSOverTLS), string(lxcify.DNSBlock),
+	},
+	"identity": {string(lxcify.EphemeralIdentity)},
+	"vpn.type": {string(lxcify.VPNWireGuard), string(lxcify.VPNOpenVPN)},
+}
+
+// Schema returns a JSON Schema describing templates, for editors to check
+// and complete them with.
+func Schema() ([]byte, error) {
+	s := schemaOf(reflect.TypeOf(Template{}), nil, false)
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = "lxcify template"
+	s.Properties["extends"] = &jsonSchema{Type: "string", Description: fieldDescriptions["extends"]}
+	// Earlier versions are migrated when parsed.
+	for version := 1; version <= SchemaVersion; version++ {
+		s.Properties["schema-version"].Enum = append(s.Properties["schema-version"].Enum, version)
+	}
+	s.Properties["parameters"].PropertyNames = &jsonSchema{Pattern: parameterNamePattern.String()}
+	// Defaults are read as strings, but may be written as the parameter's
+	// type.
+	parameter := s.Properties["parameters"].AdditionalProperties.(*jsonSchema)
+	parameter.Properties["default"].Type = []string{"string", "integer", "boolean"}
+	// Templates which extend another inherit its required keys.
+	s.AnyOf = []*jsonSchema{{Required: []string{"extends"}}, {Required: s.Required}}
+	s.Required = nil
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(out, '\n'), nil
+}
+
+// schemaOf describes the YAML encoding of a type. Keys without omitempty are
+// required in sequence and map items, which are not merged with extended
+// templates, and at the top level.
+func schemaOf(t reflect.Type, path []string, item bool) *jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	key := strings.Join(path, ".")
+	s := &jsonSchema{Description: fieldDescriptions[key]}
+	for _, v := range fieldEnums[key] {
+		s.Enum = append(s.Enum, v)
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s.Type = "object"
+		s.Properties = make(map[string]*jsonSchema)
+		s.AdditionalProperties = false
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			tag := strings.Split(f.Tag.Get("yaml"), ",")
+			name := tag[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			s.Properties[name] = schemaOf(f.Type, append(path, name), false)
+			if (item || len(path) == 0) && !contains(tag[1:], "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+	case reflect.Map:
+		s.Type = "object"
+		s.AdditionalProperties = schemaOf(t.Elem(), path, true)
+		s.AdditionalProperties.(*jsonSchema).Description = ""
+	case reflect.Slice:
+		s.Type = "array"
+		s.Items = schemaOf(t.Elem(), path, true)
+		s.Items.Description = ""
+	case reflect.String:
+		s.Type = "string"
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int:
+		s.Type = "integer"
+	case reflect.Float64:
+		s.Type = "number"
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+type SchemaSuite struct{}
+
+var _ = gc.Suite(&SchemaSuite{})
+
+// templateKeys returns the dotted paths of every key in the template format.
+func templateKeys(t reflect.Type, path []string, keys map[string]bool) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for key, ft := range yamlFields(t) {
+		keyPath := append(append([]string(nil), path...), key)
+		keys[strings.Join(keyPath, ".")] = true
+		templateKeys(ft, keyPath, keys)
+	}
+}
+
+func (*SchemaSuite) TestDescriptions(c *gc.C) {
+	keys := map[string]bool{"extends": true}
+	templateKeys(reflect.TypeOf(Template{}), nil, keys)
+	for key := range keys {
+		c.Check(fieldDescriptions[key], gc.Not(gc.Equals), "", gc.Commentf("%s has no description", key))
+	}
+	for key := range fieldDescriptions {
+		c.Check(keys[key], gc.Equals, true, gc.Commentf("%s is described but not a template key", key))
+	}
+	for key := range fieldEnums {
+		c.Check(keys[key], gc.Equals, true, gc.Commentf("%s has values but is not a template key", key))
+	}
+}
+
+func (*SchemaSuite) TestSchemaFile(c *gc.C) {
+	schema, err := Schema()
+	c.Assert(err, gc.IsNil)
+	committed, err := ioutil.ReadFile("schema.json")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(committed), gc.Equals, string(schema),
+		gc.Commentf("schema.json is out of date, regenerate it with lxcify template schema"))
+}
+
+func (*SchemaSuite) TestSchema(c *gc.C) {
+	schema, err := Schema()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(schema), gc.Matches, `(?s).*"network": \{.*"mode": \{.*"enum": \[\s*"none",\s*"nat",.*`)
+	c.Assert(string(schema), gc.Matches, `(?s).*"required": \[\s*"destination"\s*\].*`)
+	c.Assert(string(schema), gc.Matches, `(?s).*"anyOf": \[.*"extends".*"container",\s*"launch-command".*`)
+	c.Assert(string(schema), gc.Matches, `(?s).*"schema-version": \{.*"enum": \[\s*1,\s*2\s*\].*`)
+	c.Assert(string(schema), gc.Matches,
+		`(?s).*"default": \{.*"type": \[\s*"string",\s*"integer",\s*"boolean"\s*\].*`)
+}
@@ -0,0 +1,259 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// ParseOption changes how templates are parsed.
+type ParseOption func(*parseOptions)
+
+type parseOptions struct {
+	warn   func(string)
+	values map[string]string
+}
+
+// Lenient reports unknown keys to warn, rather than failing.
+func Lenient(warn func(string)) ParseOption {
+	return func(o *parseOptions) {
+		o.warn = warn
+	}
+}
+
+func newParseOptions(options []ParseOption) *parseOptions {
+	o := &parseOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// checkKeys checks a parsed document for keys which do not correspond to a
+// field of t. Unknown keys are errors, unless parsing leniently. The decoder
+// does not record positions, so those in messages are estimated by locateKey,
+// and omitted if it cannot find the key, and prefixed with filename if known.
+func (o *parseOptions) checkKeys(src []byte, filename string, doc interface{}, t reflect.Type, extra ...string) error {
+	var problems []string
+	report := func(path []string, key string, known []string) {
+		line, col := locateKey(src, append(path, key))
+		msg := fmt.Sprintf("unknown key %q", key)
+		if len(path) > 0 {
+			msg += " in " + strings.Join(path, ".")
+		}
+		if suggestion := suggest(key, known); suggestion != "" {
+			msg += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		switch {
+		case line == 0 && filename != "":
+			msg = fmt.Sprintf("%s: %s", filename, msg)
+		case line == 0:
+			// The key could not be located, so is reported without
+			// a position.
+		case filename != "":
+			msg = fmt.Sprintf("%s:%d:%d: %s", filename, line, col, msg)
+		default:
+			msg = fmt.Sprintf("line %d, column %d: %s", line, col, msg)
+		}
+		problems = append(problems, msg)
+	}
+	walkKeys(doc, t, nil, extra, report)
+	if len(problems) == 0 {
+		return nil
+	}
+	if o.warn != nil {
+		for _, problem := range problems {
+			o.warn(problem)
+		}
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// walkKeys reports keys in v which are not fields of t.
+func walkKeys(v interface{}, t reflect.Type, path []string, extra []string,
+	report func(path []string, key string, known []string)) {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		fields := yamlFields(t)
+		known := append([]string(nil), extra...)
+		for key := range fields {
+			known = append(known, key)
+		}
+		sort.Strings(known)
+		var keys []string
+		for k := range m {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ft, ok := fields[key]
+			if !ok {
+				if !contains(extra, key) {
+					report(path, key, known)
+				}
+				continue
+			}
+			walkKeys(m[key], ft, append(path, key), nil, report)
+		}
+	case reflect.Map:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		keys := make(map[string]interface{})
+		var names []string
+		for k := range m {
+			names = append(names, fmt.Sprint(k))
+			keys[fmt.Sprint(k)] = k
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			walkKeys(m[keys[name]], t.Elem(), append(path, name), nil, report)
+		}
+	case reflect.Slice:
+		items, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for _, item := range items {
+			walkKeys(item, t.Elem(), path, nil, report)
+		}
+	}
+}
+
+// yamlFields returns the types of a struct's fields by YAML key.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields[key] = f.Type
+	}
+	return fields
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// locateKey returns the line and column of a key in a YAML document, found by
+// searching the text for each key along its path in turn. This is a
+// heuristic: a matching key elsewhere, such as in a block scalar, may be found
+// instead. Zero is returned for both if the key is not found.
+func locateKey(src []byte, path []string) (line, col int) {
+	lines := strings.Split(string(src), "\n")
+	i, offset := 0, 0
+	for _, key := range path {
+		re := regexp.MustCompile(`(^|[\s{,-])("` + regexp.QuoteMeta(key) + `"|'` +
+			regexp.QuoteMeta(key) + `'|` + regexp.QuoteMeta(key) + `)\s*:`)
+		found := false
+		for ; i < len(lines); i++ {
+			loc := re.FindStringSubmatchIndex(lines[i][offset:])
+			if loc != nil {
+				offset += loc[4]
+				found = true
+				break
+			}
+			offset = 0
+		}
+		if !found {
+			return 0, 0
+		}
+		line, col = i+1, offset+1
+		offset += len(key)
+	}
+	return line, col
+}
+
+// suggest returns the known key closest to a misspelled key, if any is close
+// enough to be a likely match.
+func suggest(key string, known []string) string {
+	normalize := func(s string) string {
+		return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(s))
+	}
+	best, bestDistance := "", len(key)/3+1
+	for _, candidate := range known {
+		if normalize(candidate) == normalize(key) {
+			return candidate
+		}
+		if d := editDistance(key, candidate); d <= bestDistance && (best == "" || d < bestDistance) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
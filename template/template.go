@@ -18,22 +18,42 @@
 package template
 
 import (
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 
 	"github.com/juju/errors"
 	"gopkg.in/lxc/go-lxc.v1"
-	"gopkg.in/yaml.v1"
 
 	"github.com/cmars/lxcify"
 )
 
 type Template struct {
-	ContainerInfo   container        `yaml:"container"`
-	Mounts          []mount          `yaml:"mounts,omitempty"`
-	SharePulseAudio bool             `yaml:"share-pulse-audio,omitempty"`
-	InstallScript   string           `yaml:"install-script"`
-	LaunchCommand   string           `yaml:"launch-command"`
-	DesktopLauncher *desktopLauncher `yaml:"desktop-launcher,omitempty"`
+	SchemaVersion   int                  `yaml:"schema-version,omitempty"`
+	Description     string               `yaml:"description,omitempty"`
+	Parameters      map[string]parameter `yaml:"parameters,omitempty"`
+	ContainerInfo   container            `yaml:"container"`
+	Mounts          []mount              `yaml:"mounts,omitempty"`
+	Audio           *audio               `yaml:"audio,omitempty"`
+	GPU             string               `yaml:"gpu,omitempty"`
+	AppArmor        *appArmor            `yaml:"apparmor,omitempty"`
+	Seccomp         *seccomp             `yaml:"seccomp,omitempty"`
+	Capabilities    *capabilities        `yaml:"capabilities,omitempty"`
+	Network         network              `yaml:"network,omitempty"`
+	Firewall        *firewall            `yaml:"firewall,omitempty"`
+	DNS             dns                  `yaml:"dns,omitempty"`
+	Identity        string               `yaml:"identity,omitempty"`
+	Ports           []port               `yaml:"ports,omitempty"`
+	VPN             *vpn                 `yaml:"vpn,omitempty"`
+	Resources       *resources           `yaml:"resources,omitempty"`
+	Repositories    []repository         `yaml:"repositories,omitempty"`
+	Packages        []string             `yaml:"packages,omitempty"`
+	Downloads       []download           `yaml:"downloads,omitempty"`
+	Files           []file               `yaml:"files,omitempty"`
+	InstallScript   string               `yaml:"install-script,omitempty"`
+	LaunchCommand   string               `yaml:"launch-command"`
+	DesktopLauncher *desktopLauncher     `yaml:"desktop-launcher,omitempty"`
 }
 
 type container struct {
@@ -71,13 +91,118 @@ type mount struct {
 	IsDir     bool   `yaml:"directory,omitempty"`
 }
 
+type audio struct {
+	PulseAudio bool `yaml:"pulseaudio,omitempty"`
+}
+
+type appArmor struct {
+	Writable []string `yaml:"writable,omitempty"`
+	Complain bool     `yaml:"complain,omitempty"`
+}
+
+type seccomp struct {
+	Preset string   `yaml:"preset,omitempty"`
+	Allow  []string `yaml:"allow,omitempty"`
+	Deny   []string `yaml:"deny,omitempty"`
+}
+
+type capabilities struct {
+	Preset string   `yaml:"preset,omitempty"`
+	Drop   []string `yaml:"drop,omitempty"`
+	Keep   []string `yaml:"keep,omitempty"`
+}
+
+type network struct {
+	Mode   string  `yaml:"mode,omitempty"`
+	Link   string  `yaml:"link,omitempty"`
+	Proxy  string  `yaml:"proxy,omitempty"`
+	Limits *limits `yaml:"limits,omitempty"`
+}
+
+type limits struct {
+	Egress  rateLimit `yaml:"egress,omitempty"`
+	Ingress rateLimit `yaml:"ingress,omitempty"`
+}
+
+type rateLimit struct {
+	Rate  string `yaml:"rate,omitempty"`
+	Burst string `yaml:"burst,omitempty"`
+}
+
+type dns struct {
+	Mode        string   `yaml:"mode,omitempty"`
+	Nameservers []string `yaml:"nameservers,omitempty"`
+}
+
+type firewall struct {
+	Allow []firewallRule `yaml:"allow,omitempty"`
+}
+
+type firewallRule struct {
+	Destination string `yaml:"destination"`
+	Ports       []int  `yaml:"ports,omitempty"`
+}
+
+type resources struct {
+	Memory      string  `yaml:"memory,omitempty"`
+	MemorySwap  string  `yaml:"memory-swap,omitempty"`
+	CPUShares   int     `yaml:"cpu-shares,omitempty"`
+	CPUQuota    float64 `yaml:"cpu-quota,omitempty"`
+	CPUSet      string  `yaml:"cpuset,omitempty"`
+	Pids        int     `yaml:"pids,omitempty"`
+	BlkioWeight int     `yaml:"blkio-weight,omitempty"`
+}
+
+type vpn struct {
+	Type   string `yaml:"type"`
+	Config string `yaml:"config"`
+}
+
+type repository struct {
+	Name       string   `yaml:"name"`
+	URL        string   `yaml:"url"`
+	Suite      string   `yaml:"suite"`
+	Components []string `yaml:"components,omitempty"`
+	Key        string   `yaml:"key"`
+	KeySHA256  string   `yaml:"key-sha256,omitempty"`
+}
+
+type download struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+	Path   string `yaml:"path"`
+	Mode   string `yaml:"mode,omitempty"`
+}
+
+type file struct {
+	Host      string `yaml:"host"`
+	Container string `yaml:"container"`
+	Mode      string `yaml:"mode,omitempty"`
+	Owner     string `yaml:"owner,omitempty"`
+}
+
+type port struct {
+	Host      int `yaml:"host"`
+	Container int `yaml:"container"`
+}
+
 type desktopLauncher struct {
-	Name     string `yaml:"name"`
-	Comment  string `yaml:"comment,omitempty"`
-	IconPath string `yaml:"icon-path"`
+	Name       string   `yaml:"name"`
+	Comment    string   `yaml:"comment,omitempty"`
+	IconPath   string   `yaml:"icon-path"`
+	Categories []string `yaml:"categories,omitempty"`
 }
 
 func (t *Template) Container(name string) (*lxcify.Container, error) {
+	options, err := t.options()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return lxcify.NewContainer(name, options...)
+}
+
+// options returns the container options described by the template.
+func (t *Template) options() ([]lxcify.Option, error) {
 	mounts, err := t.mounts()
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -88,29 +213,166 @@ func (t *Template) Container(name string) (*lxcify.Container, error) {
 		lxcify.Template(t.ContainerInfo.Template),
 		lxcify.Target(t.ContainerInfo.Distro, t.ContainerInfo.Release, t.ContainerInfo.Arch),
 		lxcify.Mounts(mounts...),
+		lxcify.Network(lxcify.NetworkPolicy{
+			Mode:   lxcify.NetworkMode(t.Network.Mode),
+			Link:   t.Network.Link,
+			Proxy:  t.Network.Proxy,
+			Limits: t.Network.Limits.bandwidthLimits(),
+		}),
+		lxcify.DNS(lxcify.DNSPolicy{
+			Mode:        lxcify.DNSMode(t.DNS.Mode),
+			Nameservers: t.DNS.Nameservers,
+		}),
 	}
-	if t.SharePulseAudio {
+	if t.Audio != nil && t.Audio.PulseAudio {
 		options = append(options, lxcify.PulseAudio(true))
 	}
-	return lxcify.NewContainer(name, options...)
+	if t.Firewall != nil {
+		var rules []lxcify.FirewallRule
+		for _, rule := range t.Firewall.Allow {
+			rules = append(rules, lxcify.FirewallRule{
+				Destination: rule.Destination,
+				Ports:       rule.Ports,
+			})
+		}
+		options = append(options, lxcify.Firewall(rules...))
+	}
+	if t.Identity != "" {
+		options = append(options, lxcify.Identity(lxcify.IdentityMode(t.Identity)))
+	}
+	if len(t.Ports) > 0 {
+		var forwards []lxcify.PortForward
+		for _, p := range t.Ports {
+			forwards = append(forwards, lxcify.PortForward{
+				HostPort:      p.Host,
+				ContainerPort: p.Container,
+			})
+		}
+		options = append(options, lxcify.Ports(forwards...))
+	}
+	if t.VPN != nil {
+		options = append(options, lxcify.VPN(&lxcify.VPNProfile{
+			Type:   lxcify.VPNType(t.VPN.Type),
+			Config: t.VPN.Config,
+		}))
+	}
+	if t.Resources != nil {
+		options = append(options, lxcify.ResourceLimits(&lxcify.Resources{
+			Memory:      t.Resources.Memory,
+			MemorySwap:  t.Resources.MemorySwap,
+			CPUShares:   t.Resources.CPUShares,
+			CPUQuota:    t.Resources.CPUQuota,
+			CPUSet:      t.Resources.CPUSet,
+			Pids:        t.Resources.Pids,
+			BlkioWeight: t.Resources.BlkioWeight,
+		}))
+	}
+	if t.GPU != "" {
+		options = append(options, lxcify.GPU(lxcify.GPUDriver(t.GPU)))
+	}
+	if t.AppArmor != nil {
+		options = append(options, lxcify.AppArmor(&lxcify.AppArmorProfile{
+			Writable: t.AppArmor.Writable,
+			Complain: t.AppArmor.Complain,
+		}))
+	}
+	if t.Seccomp != nil {
+		options = append(options, lxcify.Seccomp(&lxcify.SeccompPolicy{
+			Preset: t.Seccomp.Preset,
+			Allow:  t.Seccomp.Allow,
+			Deny:   t.Seccomp.Deny,
+		}))
+	}
+	if t.Capabilities != nil {
+		options = append(options, lxcify.Capabilities(&lxcify.CapabilitySet{
+			Preset: t.Capabilities.Preset,
+			Drop:   t.Capabilities.Drop,
+			Keep:   t.Capabilities.Keep,
+		}))
+	}
+	return options, nil
 }
 
 func (t *Template) App() (*lxcify.App, error) {
-	if t.InstallScript == "" {
-		return nil, errors.New("missing install-script")
+	if !t.installs() {
+		return nil, errors.New("missing install-script, packages, downloads or files")
 	}
 	if t.LaunchCommand == "" {
 		return nil, errors.New("missing launch-command")
 	}
+	return t.app()
+}
 
+// installs returns whether the template installs anything.
+func (t *Template) installs() bool {
+	return t.InstallScript != "" || len(t.Packages) > 0 || len(t.Downloads) > 0 || len(t.Files) > 0
+}
+
+func (t *Template) app() (*lxcify.App, error) {
 	app := &lxcify.App{
+		Packages:      t.Packages,
 		InstallScript: t.InstallScript,
 		LaunchCommand: t.LaunchCommand,
 	}
+	for _, r := range t.Repositories {
+		app.Repositories = append(app.Repositories, lxcify.Repository{
+			Name:       r.Name,
+			URL:        r.URL,
+			Suite:      r.Suite,
+			Components: r.Components,
+			Key:        r.Key,
+			KeySHA256:  r.KeySHA256,
+		})
+	}
+	for _, d := range t.Downloads {
+		mode, err := parseMode(d.Mode)
+		if err != nil {
+			return nil, errors.Annotatef(err, "download %s", d.URL)
+		}
+		app.Downloads = append(app.Downloads, lxcify.Download{
+			URL:    d.URL,
+			SHA256: d.SHA256,
+			Path:   d.Path,
+			Mode:   mode,
+		})
+	}
+	for _, f := range t.Files {
+		mode, err := parseMode(f.Mode)
+		if err != nil {
+			return nil, errors.Annotatef(err, "file %q", f.Host)
+		}
+		app.Files = append(app.Files, lxcify.File{
+			Host:      f.Host,
+			Container: f.Container,
+			Mode:      mode,
+			Owner:     f.Owner,
+		})
+	}
 	app.DesktopLauncher = t.DesktopLauncher.desktopLauncher()
 	return app, nil
 }
 
+// parseMode parses octal permissions, which are zero if unset.
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.Errorf("invalid mode %q, expected octal permissions such as 0644", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ComplainAppArmor generates the container's AppArmor profile in complain
+// mode, whether or not the template declares one.
+func (t *Template) ComplainAppArmor() {
+	if t.AppArmor == nil {
+		t.AppArmor = &appArmor{}
+	}
+	t.AppArmor.Complain = true
+}
+
 func (t *Template) mounts() ([]lxcify.Mount, error) {
 	var mounts []lxcify.Mount
 	for _, mountConfig := range t.Mounts {
@@ -131,8 +393,17 @@ func (m *mount) mount() (lxcify.Mount, error) {
 		if m.Host != "" || m.Container != "" {
 			return fail, errors.Trace(errMountConfigInvalid)
 		}
+		if !filepath.IsAbs(m.Passthru) {
+			return fail, errors.Errorf("passthru path %q must be absolute", m.Passthru)
+		}
 		return lxcify.PassthruMount(m.Passthru, m.IsDir), nil
 	} else if m.Host != "" && m.Container != "" {
+		if !filepath.IsAbs(m.Host) {
+			return fail, errors.Errorf("host path %q must be absolute", m.Host)
+		}
+		if !filepath.IsAbs(m.Container) {
+			return fail, errors.Errorf("container path %q must be absolute", m.Container)
+		}
 		return lxcify.Mount{
 			Host:      m.Host,
 			Container: m.Container[1:],
@@ -142,22 +413,30 @@ func (m *mount) mount() (lxcify.Mount, error) {
 	return fail, errors.New("missing required fields: {passthru} or {host,container}")
 }
 
+func (l *limits) bandwidthLimits() lxcify.BandwidthLimits {
+	if l == nil {
+		return lxcify.BandwidthLimits{}
+	}
+	return lxcify.BandwidthLimits{
+		Egress:  lxcify.RateLimit{Rate: l.Egress.Rate, Burst: l.Egress.Burst},
+		Ingress: lxcify.RateLimit{Rate: l.Ingress.Rate, Burst: l.Ingress.Burst},
+	}
+}
+
 func (dl *desktopLauncher) desktopLauncher() *lxcify.DesktopLauncher {
 	if dl == nil {
 		return nil
 	}
 	return &lxcify.DesktopLauncher{
-		Name:     dl.Name,
-		Comment:  dl.Comment,
-		IconPath: dl.IconPath,
+		Name:       dl.Name,
+		Comment:    dl.Comment,
+		IconPath:   dl.IconPath,
+		Categories: dl.Categories,
 	}
 }
 
-func Parse(in []byte) (*Template, error) {
-	var template Template
-	err := yaml.Unmarshal(in, &template)
-	if err != nil {
-		return nil, errors.Trace(err)
-	}
-	return &template, nil
+// Parse parses a template. Any templates it extends are relative to the
+// current directory. Unknown keys are errors, unless parsing leniently.
+func Parse(in []byte, options ...ParseOption) (*Template, error) {
+	return parse(in, "", ".", newParseOptions(options))
 }
@@ -72,7 +72,7 @@ func (*ConfigSuite) TestContent(c *gc.C) {
 			Container: "/dev/video0",
 			IsDir:     false,
 		})
-	c.Assert(t.SharePulseAudio, gc.Equals, true)
+	c.Assert(t.Audio, gc.DeepEquals, &audio{PulseAudio: true})
 	c.Assert(t.InstallScript, gc.Matches, "(?m).*apt-get update.*")
 	c.Assert(t.LaunchCommand, gc.Equals, "/bin/beef")
 	c.Assert(t.DesktopLauncher, gc.NotNil)
@@ -116,7 +116,10 @@ func (*ConfigSuite) TestAppReqFields(c *gc.C) {
 		yaml: testYaml,
 	}, {
 		yaml:       "nope:nope:nope:",
-		errPattern: "missing install-script",
+		errPattern: "missing install-script, packages, downloads or files",
+	}, {
+		yaml:       "launch-command: b",
+		errPattern: "missing install-script, packages, downloads or files",
 	}, {
 		yaml:       `install-script: a`,
 		errPattern: "missing launch-command",
@@ -127,7 +130,7 @@ func (*ConfigSuite) TestAppReqFields(c *gc.C) {
 
 	for i, testCase := range testCases {
 		c.Log("test#", i)
-		t, err := Parse([]byte(testCase.yaml))
+		t, err := Parse([]byte(testCase.yaml), Lenient(func(string) {}))
 		c.Assert(err, gc.IsNil)
 		app, err := t.App()
 		if testCase.errPattern == "" {
@@ -138,6 +141,11 @@ func (*ConfigSuite) TestAppReqFields(c *gc.C) {
 			c.Assert(err, gc.ErrorMatches, testCase.errPattern)
 		}
 	}
+
+	// Parsed strictly, the malformed template is rejected before the app's
+	// fields are checked.
+	_, err := Parse([]byte("nope:nope:nope:"))
+	c.Assert(err, gc.ErrorMatches, `line 1, column 1: unknown key "nope:nope:nope"`)
 }
 
 func (*ConfigSuite) TestCreateContainer(c *gc.C) {
@@ -150,3 +158,423 @@ func (*ConfigSuite) TestCreateContainer(c *gc.C) {
 
 	c.Assert(container.Name(), gc.Equals, "foo")
 }
+
+// templateTest is a template, and the error expected when converting it, if
+// any.
+type templateTest struct {
+	yaml       string
+	errPattern string
+}
+
+// checkContainers converts each template into a container.
+func checkContainers(c *gc.C, testCases []templateTest) {
+	for i, testCase := range testCases {
+		c.Logf("test#%d: %s", i, testCase.yaml)
+		t, err := Parse([]byte(testCase.yaml))
+		c.Assert(err, gc.IsNil)
+		_, err = t.Container("foo")
+		if testCase.errPattern == "" {
+			c.Assert(err, gc.IsNil)
+		} else {
+			c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+		}
+	}
+}
+
+func (*ConfigSuite) TestGPU(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "gpu: nvidia"},
+		{yaml: "container: {template: ubuntu}"},
+		{yaml: "gpu: voodoo", errPattern: `unsupported gpu "voodoo"`},
+	})
+}
+
+func (*ConfigSuite) TestAppArmor(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "apparmor: {writable: [/home/ubuntu/Downloads], complain: true}"},
+		{yaml: "apparmor: {}"},
+		{
+			yaml:       "apparmor: {writable: [home/ubuntu]}",
+			errPattern: `apparmor writable path "home/ubuntu" must be absolute`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestSeccomp(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "seccomp: {preset: browser, allow: [ptrace], deny: [mount]}"},
+		{yaml: "seccomp: {deny: [mount]}"},
+		{yaml: "seccomp: {preset: paranoid}", errPattern: `unknown seccomp preset "paranoid"`},
+		{yaml: "seccomp: {deny: [rm -rf]}", errPattern: `invalid system call name "rm -rf"`},
+		{
+			yaml:       "{container: {arch: arm64}, seccomp: {deny: [iopl]}}",
+			errPattern: `system call "iopl" is not available on arm64`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestCapabilities(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "capabilities: {preset: hardened, drop: [CAP_NET_RAW]}"},
+		{yaml: "capabilities: {preset: none}"},
+		{yaml: "capabilities: {keep: [chown, setuid, setgid]}"},
+		{yaml: "capabilities: {keep: [none]}"},
+		{
+			yaml:       "capabilities: {preset: hardened, keep: [chown]}",
+			errPattern: "capabilities drop and keep are mutually exclusive",
+		},
+		{
+			yaml:       "capabilities: {drop: [chown], keep: [setuid]}",
+			errPattern: "capabilities drop and keep are mutually exclusive",
+		},
+		{yaml: "capabilities: {drop: [sys_everything]}", errPattern: `unknown capability "sys_everything"`},
+		{yaml: "capabilities: {preset: paranoid}", errPattern: `unknown capabilities preset "paranoid"`},
+	})
+}
+
+func (*ConfigSuite) TestNetwork(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "launch-command: x"},
+		{yaml: "network: {mode: none}"},
+		{yaml: "network: {mode: nat, link: br0}"},
+		{yaml: "network: {mode: host-only, link: br1}"},
+		{yaml: "network: {mode: host-only}", errPattern: `"host-only" network mode requires a link`},
+		{yaml: "network: {mode: proxy, proxy: 'socks5://10.0.3.1:1080'}"},
+		{yaml: "network: {mode: tor}"},
+		{
+			yaml:       "network: {mode: proxy}",
+			errPattern: `invalid proxy "", expected scheme://host:port`,
+		},
+		{
+			yaml:       "network: {mode: proxy, proxy: 'ftp://10.0.4.1'}",
+			errPattern: `invalid proxy "ftp://10.0.4.1", expected scheme://host:port`,
+		},
+		{
+			yaml:       "network: {mode: proxy, proxy: 'http://10.0.4.1'}",
+			errPattern: `invalid proxy "http://10.0.4.1", expected scheme://host:port`,
+		},
+		{
+			yaml:       "network: {mode: nat, proxy: 'http://10.0.4.1:3128'}",
+			errPattern: `proxy is only supported in "proxy" network mode`,
+		},
+		{
+			yaml:       "network: {mode: none, link: br0}",
+			errPattern: `link is not supported in "none" network mode`,
+		},
+		{yaml: "network: {mode: nat, limits: {egress: {rate: 2mbit, burst: 64kb}, ingress: {rate: 10mbit}}}"},
+		{yaml: "network: {limits: {ingress: {rate: 1.5mbit}}}"},
+		{
+			yaml:       "network: {limits: {egress: {rate: fast}}}",
+			errPattern: `invalid egress rate "fast", expected a tc rate such as 2mbit`,
+		},
+		{
+			yaml:       "network: {limits: {ingress: {rate: 1mbit, burst: lots}}}",
+			errPattern: `invalid ingress burst "lots", expected a tc size such as 64kb`,
+		},
+		{yaml: "network: {limits: {egress: {burst: 64kb}}}", errPattern: "egress burst requires a rate"},
+		{
+			yaml:       "network: {mode: none, limits: {egress: {rate: 1mbit}}}",
+			errPattern: `limits are not supported in "none" network mode`,
+		},
+		{yaml: "network: {mode: carrier-pigeon}", errPattern: `unknown network mode "carrier-pigeon"`},
+	})
+}
+
+func (*ConfigSuite) TestFirewall(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: `
+firewall:
+  allow:
+    - destination: intranet.example.com
+      ports: [80, 443]
+    - destination: 10.0.0.0/8
+    - destination: 192.0.2.1
+`},
+		{yaml: "firewall: {}"},
+		{
+			yaml:       "firewall: {allow: [{destination: 2001:db8::1}]}",
+			errPattern: `firewall destination "2001:db8::1": IPv6 is not supported`,
+		},
+		{
+			yaml:       "firewall: {allow: [{destination: 'example.com; flush ruleset'}]}",
+			errPattern: `invalid firewall destination "example.com; flush ruleset"`,
+		},
+		{
+			yaml:       "firewall: {allow: [{destination: example.com, ports: [0]}]}",
+			errPattern: `invalid firewall port 0 for "example.com"`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestDNS(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "dns: {mode: custom, nameservers: [9.9.9.9, 149.112.112.112]}"},
+		{yaml: "dns: {mode: tls, nameservers: ['1.1.1.1#cloudflare-dns.com', 9.9.9.9]}"},
+		{yaml: "dns: {mode: block}"},
+		{yaml: "dns: {mode: custom}", errPattern: `"custom" dns mode requires nameservers`},
+		{
+			yaml:       "dns: {mode: block, nameservers: [9.9.9.9]}",
+			errPattern: `nameservers are not supported in "block" dns mode`,
+		},
+		{
+			yaml:       "dns: {mode: custom, nameservers: [dns.quad9.net]}",
+			errPattern: `invalid nameserver "dns.quad9.net", expected an IPv4 address`,
+		},
+		{
+			yaml:       "dns: {mode: custom, nameservers: ['9.9.9.9#dns.quad9.net']}",
+			errPattern: `invalid nameserver "9.9.9.9#dns.quad9.net"`,
+		},
+		{
+			yaml:       "{dns: {mode: block}, network: {mode: tor}}",
+			errPattern: `dns "block" is not supported in "tor" network mode`,
+		},
+		{yaml: "dns: {mode: carrier-pigeon}", errPattern: `unknown dns mode "carrier-pigeon"`},
+	})
+}
+
+func (*ConfigSuite) TestPorts(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "ports: [{host: 8384, container: 8384}, {host: 8080, container: 80}]"},
+		{yaml: "ports: [{host: 0, container: 80}]", errPattern: "invalid port forward 0:80"},
+		{yaml: "ports: [{host: 8080, container: 70000}]", errPattern: "invalid port forward 8080:70000"},
+		{
+			yaml:       "{network: {mode: none}, ports: [{host: 8080, container: 80}]}",
+			errPattern: `ports are not supported in "none" network mode`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestVPN(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "vpn: {type: wireguard, config: testdata/wg0.conf}"},
+		{yaml: "vpn: {type: openvpn, config: testdata/client.ovpn}"},
+		{yaml: "{firewall: {}, vpn: {type: wireguard, config: testdata/wg0.conf}}"},
+		{yaml: "vpn: {type: ipsec, config: testdata/wg0.conf}", errPattern: `unknown vpn type "ipsec"`},
+		{yaml: "vpn: {type: wireguard}", errPattern: "wireguard vpn requires a config file"},
+		{
+			yaml:       "vpn: {type: openvpn, config: testdata/empty.ovpn}",
+			errPattern: `no endpoints found in vpn config "testdata/empty.ovpn"`,
+		},
+		{yaml: "vpn: {type: openvpn, config: testdata/missing}", errPattern: "cannot read vpn config: .*"},
+		{
+			yaml:       "{network: {mode: tor}, vpn: {type: wireguard, config: testdata/wg0.conf}}",
+			errPattern: `vpn is not supported in "tor" network mode`,
+		},
+		{
+			yaml:       "{dns: {mode: block}, vpn: {type: wireguard, config: testdata/wg0.conf}}",
+			errPattern: `dns "block" is not supported with a vpn`,
+		},
+		{
+			yaml:       "{firewall: {allow: [{destination: 10.0.0.0/8}]}, vpn: {type: wireguard, config: testdata/wg0.conf}}",
+			errPattern: "firewall rules are not supported with a vpn",
+		},
+	})
+}
+
+func (*ConfigSuite) TestIdentity(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "identity: ephemeral"},
+		{yaml: "identity: anonymous", errPattern: `unknown identity "anonymous"`},
+	})
+}
+
+func (*ConfigSuite) TestResources(c *gc.C) {
+	checkContainers(c, []templateTest{
+		{yaml: "resources: {memory: 2G, memory-swap: 3G, cpu-shares: 512, cpu-quota: 1.5, cpuset: '0-1,3', pids: 1024, blkio-weight: 300}"},
+		{yaml: "resources: {memory: 512m, cpu-quota: 0.01}"},
+		{yaml: "resources: {memory: lots}", errPattern: `invalid memory size "lots"`},
+		{yaml: "resources: {memory-swap: 1G}", errPattern: "memory-swap requires memory"},
+		{
+			yaml:       "resources: {memory: 2G, memory-swap: 1G}",
+			errPattern: `memory-swap "1G" is less than memory "2G"`,
+		},
+		{yaml: "resources: {cpu-shares: 1}", errPattern: `invalid cpu-shares 1, expected 2 to 262144`},
+		{
+			yaml:       "resources: {cpu-quota: 0.005}",
+			errPattern: `cpu-quota 0.005 is less than the minimum of 0.01`,
+		},
+		{yaml: "resources: {cpuset: 'all'}", errPattern: `invalid cpuset "all"`},
+		{yaml: "resources: {blkio-weight: 5000}", errPattern: `invalid blkio-weight 5000, expected 10 to 1000`},
+	})
+}
+
+func (*ConfigSuite) TestEmptyFirewall(c *gc.C) {
+	for i, in := range []string{"firewall:", "firewall: {}", "firewall: {allow: []}"} {
+		c.Log("test#", i)
+		t, err := Parse([]byte(in))
+		c.Assert(err, gc.IsNil)
+		c.Assert(t.Firewall, gc.NotNil)
+		c.Assert(t.Firewall.Allow, gc.HasLen, 0)
+	}
+}
+
+// appTestSHA256 is a SHA-256 for download test cases.
+const appTestSHA256 = "0f343b0931126a20f133d67c2b018a3b4b7bfb7e1e6a3dd2a6a3b5d4f1c7e0d1"
+
+// checkApps converts each template into an app, which is checked against the
+// template's distro, or ubuntu.
+func checkApps(c *gc.C, testCases []templateTest) {
+	for i, testCase := range testCases {
+		c.Logf("test#%d: %s", i, testCase.yaml)
+		t, err := Parse([]byte(testCase.yaml + "\nlaunch-command: app\n"))
+		c.Assert(err, gc.IsNil)
+		distro := t.ContainerInfo.Distro
+		if distro == "" {
+			distro = "ubuntu"
+		}
+		app, err := t.app()
+		if err == nil {
+			err = app.Check(distro)
+		}
+		if testCase.errPattern == "" {
+			c.Assert(err, gc.IsNil)
+		} else {
+			c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+		}
+	}
+}
+
+func (*ConfigSuite) TestPackages(c *gc.C) {
+	checkApps(c, []templateTest{
+		{
+			yaml: `
+packages: [firefox, libice6:i386, xz-utils=5.1.1alpha+20120614-2ubuntu2]
+repositories:
+  - name: google-chrome
+    url: https://dl.google.com/linux/chrome/deb/
+    suite: stable
+    components: [main]
+    key: https://dl.google.com/linux/linux_signing_key.pub
+downloads:
+  - url: https://example.com/app.tar.xz
+    sha256: ` + appTestSHA256 + `
+    path: /tmp/app.tar.xz
+`,
+		},
+		{
+			yaml:       "container: {distro: fedora}\npackages: [firefox]",
+			errPattern: `packages are not supported on distro "fedora", expected one of ubuntu, debian`,
+		},
+		{
+			yaml:       "packages: [firefox; rm -rf /]",
+			errPattern: `invalid package "firefox; rm -rf /"`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestRepositories(c *gc.C) {
+	checkApps(c, []templateTest{
+		{
+			yaml:       "repositories: [{name: a, url: 'ftp://example.com', suite: stable, key: 'https://example.com/key'}]",
+			errPattern: `repository "a": invalid url "ftp://example.com"`,
+		},
+		{
+			yaml: `repositories:
+  - {name: a, url: 'https://example.com', suite: stable, key: 'https://example.com/key'}
+  - {name: a, url: 'https://example.com', suite: testing, key: 'https://example.com/key'}`,
+			errPattern: `duplicate repository "a"`,
+		},
+		{
+			yaml:       "repositories: [{name: a, url: 'http://example.com', suite: stable, key: 'http://example.com/key'}]",
+			errPattern: `repository "a": key url "http://example.com/key" must be https, or key-sha256 given`,
+		},
+		{
+			yaml: "repositories: [{name: a, url: 'http://example.com', suite: stable, key: 'http://example.com/key', key-sha256: " + appTestSHA256 + "}]",
+		},
+	})
+}
+
+func (*ConfigSuite) TestDownloads(c *gc.C) {
+	checkApps(c, []templateTest{
+		{
+			yaml: "downloads: [{url: 'file:///srv/mirror/app.deb', sha256: " + appTestSHA256 + ", path: /tmp/app.deb}]",
+		},
+		{
+			yaml:       "downloads: [{url: 'file:app.deb', sha256: " + appTestSHA256 + ", path: /tmp/app.deb}]",
+			errPattern: `invalid download url "file:app.deb", expected http, https or file:///path`,
+		},
+		{
+			yaml:       "downloads: [{url: 'https://example.com/app', sha256: abc, path: /tmp/app}]",
+			errPattern: `download https://example.com/app: invalid sha256 "abc", expected 64 hex digits`,
+		},
+		{
+			yaml:       "downloads: [{url: 'https://example.com/app', sha256: " + appTestSHA256 + ", path: app}]",
+			errPattern: `download https://example.com/app: invalid path "app", expected an absolute path`,
+		},
+		{
+			yaml: "downloads: [{url: 'https://example.com/app', sha256: " + appTestSHA256 + ", path: /opt/app, mode: '0755'}]",
+		},
+		{
+			yaml:       "downloads: [{url: 'https://example.com/app', sha256: " + appTestSHA256 + ", path: /opt/app, mode: '4755'}]",
+			errPattern: `download https://example.com/app: invalid mode "4755", expected octal permissions such as 0644`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestFiles(c *gc.C) {
+	checkApps(c, []templateTest{
+
+		{
+			yaml: "files: [{host: /etc/ssl/certs/ca.pem, container: /usr/local/share/ca-certificates/ca.crt, mode: '0644', owner: root}]",
+		},
+		{
+			yaml: "files: [{host: /srv/profile, container: /home/ubuntu/.mozilla, owner: 'ubuntu:ubuntu'}]",
+		},
+		{
+			yaml:       "files: [{host: /srv/profile, container: .mozilla}]",
+			errPattern: `file "/srv/profile": invalid container path ".mozilla", expected an absolute path`,
+		},
+		{
+			yaml:       "files: [{host: /srv/profile, container: /home/ubuntu/.mozilla, mode: rw}]",
+			errPattern: `file "/srv/profile": invalid mode "rw", expected octal permissions such as 0644`,
+		},
+		{
+			yaml:       "files: [{host: /srv/profile, container: /home/ubuntu/.mozilla, mode: '4755'}]",
+			errPattern: `file "/srv/profile": invalid mode "4755", expected octal permissions such as 0644`,
+		},
+		{
+			yaml:       "files: [{host: /srv/profile, container: /home/ubuntu/.mozilla, owner: 'ubuntu; rm'}]",
+			errPattern: `file "/srv/profile": invalid owner "ubuntu; rm"`,
+		},
+	})
+}
+
+func (*ConfigSuite) TestStrict(c *gc.C) {
+	testCases := []struct {
+		yaml       string
+		errPattern string
+	}{{
+		yaml: "desktop-launcher:\n  name: Chrome\n  iconPath: /chrome.png\n",
+		errPattern: `line 3, column 3: unknown key "iconPath" in desktop-launcher, ` +
+			`did you mean "icon-path"\?`,
+	}, {
+		yaml: "mounts:\n  - passthru: /dev/dri\n  - {passthru: /dev/snd, directroy: true}\n",
+		errPattern: `line 3, column 26: unknown key "directroy" in mounts, ` +
+			`did you mean "directory"\?`,
+	}, {
+		yaml:       "share-pulse: true",
+		errPattern: `line 1, column 1: unknown key "share-pulse"`,
+	}, {
+		yaml:       "nope:nope:nope:",
+		errPattern: `line 1, column 1: unknown key "nope:nope:nope"`,
+	}, {
+		// Keys which cannot be located are reported without a position.
+		yaml:       "? nope\n: 1\n",
+		errPattern: `unknown key "nope"`,
+	}}
+
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		_, err := Parse([]byte(testCase.yaml))
+		c.Assert(err, gc.ErrorMatches, testCase.errPattern)
+
+		var warnings []string
+		t, err := Parse([]byte(testCase.yaml), Lenient(func(msg string) {
+			warnings = append(warnings, msg)
+		}))
+		c.Assert(err, gc.IsNil)
+		c.Assert(t, gc.NotNil)
+		c.Assert(warnings, gc.HasLen, 1)
+		c.Assert(warnings[0], gc.Matches, testCase.errPattern)
+	}
+}
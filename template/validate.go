@@ -0,0 +1,236 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/cmars/lxcify"
+)
+
+// Issue is a problem found by validating a template.
+type Issue struct {
+	// Warning is set for problems which do not stop the template from
+	// working, but may not be intended.
+	Warning bool
+
+	// Field is the template key the issue concerns, such as
+	// "mounts[1].container".
+	Field string
+
+	Message string
+}
+
+func (i Issue) String() string {
+	severity := "error"
+	if i.Warning {
+		severity = "warning"
+	}
+	if i.Field == "" {
+		return fmt.Sprintf("%s: %s", severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", severity, i.Field, i.Message)
+}
+
+var knownReleases = map[string][]string{
+	"ubuntu": {"precise", "trusty", "utopic", "vivid", "wily", "xenial", "yakkety", "zesty",
+		"artful", "bionic", "cosmic", "disco", "eoan", "focal", "groovy", "hirsute", "impish",
+		"jammy", "kinetic", "lunar", "mantic", "noble"},
+	"debian": {"wheezy", "jessie", "stretch", "buster", "bullseye", "bookworm", "trixie", "sid"},
+}
+
+var knownArchs = []string{"amd64", "i386", "armhf", "arm64", "ppc64el", "s390x"}
+
+// riskyMounts are host paths which expose sensitive devices or services to a
+// container, with what they expose. A trailing * matches any suffix.
+var riskyMounts = []struct {
+	path, risk string
+}{
+	{"/tmp/.X11-unix", "the X11 socket lets the container read keystrokes and screen contents of other X clients"},
+	{"/dev/video*", "the camera lets the container record video"},
+	{"/dev/snd", "sound devices let the container record from microphones"},
+	{"/var/run/docker.sock", "the Docker socket gives the container root on the host"},
+	{"/run/docker.sock", "the Docker socket gives the container root on the host"},
+	{"/run/user", "the user runtime directory exposes session services"},
+	{"/etc", "host configuration is exposed"},
+}
+
+// Validate checks the template for problems which would otherwise only be
+// found when the container is created, or not at all. A template with no
+// issues other than warnings can be created.
+func (t *Template) Validate() []Issue {
+	var issues []Issue
+	add := func(warning bool, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{warning, field, fmt.Sprintf(format, args...)})
+	}
+
+	if !t.installs() {
+		add(false, "install-script", "required, unless there are packages, downloads or files")
+	}
+	if t.LaunchCommand == "" {
+		add(false, "launch-command", "required")
+	}
+	if t.DesktopLauncher != nil && t.DesktopLauncher.Name == "" {
+		add(false, "desktop-launcher.name", "required")
+	}
+
+	info := t.ContainerInfo
+	for _, field := range []struct{ name, value string }{
+		{"template", info.Template}, {"distro", info.Distro},
+		{"release", info.Release}, {"arch", info.Arch},
+	} {
+		if field.value == "" {
+			add(false, "container."+field.name, "required")
+		}
+	}
+	if releases, ok := knownReleases[info.Distro]; ok && info.Release != "" && !contains(releases, info.Release) {
+		add(true, "container.release", "unknown %s release %q", info.Distro, info.Release)
+	}
+	if info.Arch != "" && !contains(knownArchs, info.Arch) {
+		add(false, "container.arch", "unknown arch %q, expected one of %s", info.Arch, strings.Join(knownArchs, ", "))
+	}
+
+	mountsValid := true
+	for i, m := range t.Mounts {
+		field := fmt.Sprintf("mounts[%d]", i)
+		_, err := m.mount()
+		if err != nil {
+			add(false, field, "%v", err)
+			mountsValid = false
+			continue
+		}
+		hostPath := m.Passthru
+		if hostPath == "" {
+			hostPath = m.Host
+		}
+		fi, err := os.Stat(hostPath)
+		if os.IsNotExist(err) {
+			// Mounts are optional, so the container starts without it.
+			add(true, field, "%q does not exist on this host, and will not be mounted", hostPath)
+		} else if err != nil {
+			add(false, field, "cannot check %q: %v", hostPath, err)
+		} else if fi.IsDir() != m.IsDir {
+			if fi.IsDir() {
+				add(false, field, "%q is a directory, but directory is not set", hostPath)
+			} else {
+				add(false, field, "%q is not a directory, but directory is set", hostPath)
+			}
+		}
+		for _, risky := range riskyMounts {
+			if matchPath(risky.path, hostPath) {
+				add(true, field, "%s: %s", hostPath, risky.risk)
+			}
+		}
+		if home := os.Getenv("HOME"); hostPath == home || hostPath == "/home" || hostPath == "/" {
+			add(true, field, "%s: exposes all of the user's files", hostPath)
+		}
+	}
+
+	// Invalid mounts have been reported already.
+	if mountsValid {
+		options, err := t.options()
+		if err == nil {
+			err = lxcify.CheckOptions(options...)
+		}
+		if err != nil {
+			add(false, "", "%v", err)
+		}
+	}
+
+	app, err := t.app()
+	if err == nil {
+		err = app.Check(info.Distro)
+	}
+	if err != nil {
+		add(false, "", "%v", err)
+	}
+	for i, f := range t.Files {
+		if _, err := os.Stat(f.Host); err != nil {
+			add(false, fmt.Sprintf("files[%d]", i), "%q does not exist on this host", f.Host)
+		}
+	}
+
+	if t.InstallScript != "" {
+		err := checkScript(t.InstallScript)
+		if err != nil {
+			add(false, "install-script", "%v", err)
+		}
+	}
+	return issues
+}
+
+func matchPath(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(p, strings.TrimSuffix(pattern, "*"))
+	}
+	return p == pattern || strings.HasPrefix(p, pattern+"/")
+}
+
+// checkScript checks a script's syntax with the shell Install runs it with,
+// falling back to sh.
+func checkScript(script string) error {
+	f, err := ioutil.TempFile("", "lxcify-install")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(script)
+	f.Close()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	shell, err := exec.LookPath("bash")
+	if err != nil {
+		shell = "sh"
+	}
+	out, err := exec.Command(shell, "-n", f.Name()).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(strings.Replace(string(out), f.Name()+":", "", -1))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return errors.Errorf("syntax error: %s", msg)
+	}
+	return nil
+}
+
+// ValidateFile parses and validates a template file. Parse errors are
+// returned as issues.
+func ValidateFile(filename string, options ...ParseOption) []Issue {
+	t, err := ParseFile(filename, options...)
+	if err != nil {
+		return []Issue{{Message: err.Error()}}
+	}
+	return t.Validate()
+}
+
+// HasErrors returns whether any of the issues are errors.
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if !issue.Warning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package template
+
+import (
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type ValidateSuite struct{}
+
+var _ = gc.Suite(&ValidateSuite{})
+
+const validYaml = `
+container: {template: ubuntu, distro: ubuntu, release: trusty, arch: amd64}
+install-script: |
+  apt-get update
+  apt-get install -y beef
+launch-command: /bin/beef
+`
+
+func (*ValidateSuite) TestValidate(c *gc.C) {
+	dir := c.MkDir()
+	hostFile := filepath.Join(dir, "file")
+	f, err := os.Create(hostFile)
+	c.Assert(err, gc.IsNil)
+	f.Close()
+
+	testCases := []struct {
+		yaml   string
+		issues []string
+	}{{
+		yaml: validYaml,
+	}, {
+		yaml: "container: {template: ubuntu, distro: ubuntu, release: trusty}",
+		issues: []string{
+			"error: install-script: required, unless there are packages, downloads or files",
+			"error: launch-command: required",
+			"error: container.arch: required",
+		},
+	}, {
+		yaml: validYaml + "container: {template: ubuntu, distro: ubuntu, release: hoary, arch: amd128}",
+		issues: []string{
+			`warning: container.release: unknown ubuntu release "hoary"`,
+			`error: container.arch: unknown arch "amd128", expected one of .*`,
+		},
+	}, {
+		yaml: validYaml + "mounts: [{host: " + hostFile + ", container: srv/file}]",
+		issues: []string{
+			`error: mounts\[0\]: container path "srv/file" must be absolute`,
+		},
+	}, {
+		yaml: validYaml + "mounts: [{passthru: " + filepath.Join(dir, "missing") + "}, {passthru: " + dir + "}]",
+		issues: []string{
+			`warning: mounts\[0\]: ".*/missing" does not exist on this host, and will not be mounted`,
+			`error: mounts\[1\]: ".*" is a directory, but directory is not set`,
+		},
+	}, {
+		yaml: validYaml + "mounts: [{passthru: /tmp/.X11-unix, directory: true}]",
+		issues: []string{
+			`(warning: mounts\[0\]: .*\n)?warning: mounts\[0\]: /tmp/.X11-unix: the X11 socket .*`,
+		},
+	}, {
+		yaml: validYaml + "network: {mode: pigeon}",
+		issues: []string{
+			`error: unknown network mode "pigeon"`,
+		},
+	}, {
+		yaml: `
+container: {template: ubuntu, distro: ubuntu, release: trusty, arch: amd64}
+install-script: |
+  if true; then
+    echo oops
+launch-command: /bin/beef
+`,
+		issues: []string{
+			`error: install-script: syntax error: .*`,
+		},
+	}}
+
+	for i, testCase := range testCases {
+		c.Log("test#", i)
+		t, err := Parse([]byte(testCase.yaml))
+		c.Assert(err, gc.IsNil)
+		var issues string
+		for _, issue := range t.Validate() {
+			issues += issue.String() + "\n"
+		}
+		var expected string
+		for _, issue := range testCase.issues {
+			expected += issue + "\n"
+		}
+		c.Assert(issues, gc.Matches, expected)
+	}
+}
+
+func (*ValidateSuite) TestHasErrors(c *gc.C) {
+	c.Assert(HasErrors(nil), gc.Equals, false)
+	c.Assert(HasErrors([]Issue{{Warning: true}}), gc.Equals, false)
+	c.Assert(HasErrors([]Issue{{Warning: true}, {}}), gc.Equals, true)
+}
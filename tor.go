@@ -0,0 +1,324 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// torDir returns the directory holding the container's Tor client state.
+func (c *Container) torDir() string {
+	return path.Join(c.ConfigPath(), c.Name(), "tor")
+}
+
+// Tor clients listen on pairs of ports from torPortBase, a TransPort and the
+// DNSPort after it.
+const (
+	torPortBase  = 20000
+	torPortSlots = 10000
+)
+
+func (c *Container) torPortsFile() string {
+	return path.Join(c.ConfigPath(), c.Name(), "tor.ports")
+}
+
+// torPortSlot returns the slot of the ports derived from the container name,
+// from which assignTorPorts searches for free ports.
+func (c *Container) torPortSlot() int {
+	h := sha1.Sum([]byte(c.Name()))
+	return int(binary.BigEndian.Uint16(h[:2]) % torPortSlots)
+}
+
+// readTorPorts reads the ports assigned to a container's Tor client.
+func readTorPorts(filename string) (transPort, dnsPort int, err error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	_, err = fmt.Sscan(string(contents), &transPort, &dnsPort)
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "invalid tor ports in %q", filename)
+	}
+	return transPort, dnsPort, nil
+}
+
+// torPorts returns the TransPort and DNSPort of the container's Tor client.
+// Each container runs its own client, on ports assigned by assignTorPorts.
+// Until they are assigned, the ports derived from the container name are
+// returned.
+func (c *Container) torPorts() (transPort, dnsPort int) {
+	transPort, dnsPort, err := readTorPorts(c.torPortsFile())
+	if err != nil {
+		transPort = torPortBase + c.torPortSlot()*2
+		return transPort, transPort + 1
+	}
+	return transPort, dnsPort
+}
+
+// assignTorPorts assigns ports to the container's Tor client, unless it
+// already has them, and stores the assignment in the container directory.
+// Starting from the slot derived from the container name, the first ports
+// not assigned to another container in the same config path, and not in use
+// on the host, are taken.
+func (c *Container) assignTorPorts() error {
+	if _, _, err := readTorPorts(c.torPortsFile()); err == nil {
+		return nil
+	}
+	assigned := make(map[int]bool)
+	dirs, err := ioutil.ReadDir(c.ConfigPath())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, dir := range dirs {
+		if dir.Name() == c.Name() {
+			continue
+		}
+		transPort, dnsPort, err := readTorPorts(path.Join(c.ConfigPath(), dir.Name(), "tor.ports"))
+		if err == nil {
+			assigned[transPort] = true
+			assigned[dnsPort] = true
+		}
+	}
+	slot := c.torPortSlot()
+	for i := 0; i < torPortSlots; i++ {
+		transPort := torPortBase + ((slot+i)%torPortSlots)*2
+		dnsPort := transPort + 1
+		if assigned[transPort] || assigned[dnsPort] || !torPortsFree(transPort, dnsPort) {
+			continue
+		}
+		return errors.Trace(ioutil.WriteFile(c.torPortsFile(),
+			[]byte(fmt.Sprintf("%d %d\n", transPort, dnsPort)), 0644))
+	}
+	return errors.New("no free ports for a tor client")
+}
+
+// torPortsFree returns whether the host has nothing listening on the ports.
+func torPortsFree(transPort, dnsPort int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", transPort))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	pc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", dnsPort))
+	if err != nil {
+		return false
+	}
+	pc.Close()
+	return true
+}
+
+// torEgressRules returns bridge rules which mark the container's IPv4 TCP and
+// DNS traffic for redirection to Tor. Redirection is only set up for IPv4, so
+// anything else, including all IPv6 traffic, is dropped.
+func (c *Container) torEgressRules() []string {
+	mark := "meta mark set " + c.firewallMark() + " accept"
+	rules := []string{"ether type ip udp dport 53 " + mark}
+	if len(c.firewall) == 0 {
+		return append(rules, "ether type ip meta l4proto tcp "+mark)
+	}
+	for _, rule := range c.firewall {
+		// Firewall rules only match IPv4 destinations.
+		rules = append(rules, rule.match()+" meta l4proto tcp "+mark)
+	}
+	return rules
+}
+
+// torRedirectRules redirects marked traffic to the Tor client on the bridge
+// address.
+func (c *Container) torRedirectRules() []string {
+	transPort, dnsPort := c.torPorts()
+	return []string{
+		fmt.Sprintf("udp dport 53 redirect to :%d", dnsPort),
+		fmt.Sprintf("meta l4proto tcp redirect to :%d", transPort),
+	}
+}
+
+// torUpScript starts a Tor client listening on the bridge the container is
+// attached to.
+const torUpScript = `#!/bin/sh -e
+TOR_DIR=%[1]q
+BRIDGE=%[2]q
+BRIDGE_IP=$(ip -4 -o addr show dev $BRIDGE | awk '{print $4}' | cut -d/ -f1 | head -n 1)
+if [ -z "$BRIDGE_IP" ]; then
+    echo "cannot find address of $BRIDGE" >&2
+    exit 1
+fi
+
+mkdir -p -m 0700 $TOR_DIR
+cat >$TOR_DIR/torrc <<TORRC
+RunAsDaemon 1
+DataDirectory $TOR_DIR
+PidFile $TOR_DIR/tor.pid
+Log notice file $TOR_DIR/tor.log
+ControlSocket $TOR_DIR/control
+CookieAuthentication 1
+SocksPort 0
+TransPort $BRIDGE_IP:%[3]d
+DNSPort $BRIDGE_IP:%[4]d
+AutomapHostsOnResolve 1
+TORRC
+tor -f $TOR_DIR/torrc
+`
+
+const torDownScript = `#!/bin/sh
+PID_FILE=%q/tor.pid
+if [ -e "$PID_FILE" ]; then
+    kill $(cat $PID_FILE) 2>/dev/null || true
+    rm -f $PID_FILE
+fi
+`
+
+// setupTor installs hooks which run a Tor client for the container while it
+// is running.
+func (c *Container) setupTor() error {
+	transPort, dnsPort := c.torPorts()
+	err := c.installHook("pre-start", "tor-up.sh",
+		fmt.Sprintf(torUpScript, c.torDir(), c.network.link(), transPort, dnsPort))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.installHook("post-stop", "tor-down.sh", fmt.Sprintf(torDownScript, c.torDir())))
+}
+
+// TorStatus describes the health of a container's Tor client.
+type TorStatus struct {
+	// Bootstrap is the client's bootstrap progress, such as
+	// `PROGRESS=100 TAG=done SUMMARY="Done"`.
+	Bootstrap string
+
+	// CircuitEstablished is whether the client has been able to build a
+	// circuit.
+	CircuitEstablished bool
+
+	// BuiltCircuits is the number of circuits currently built.
+	BuiltCircuits int
+}
+
+// TorStatus queries the container's Tor client over its control socket. An
+// error satisfying errors.IsNotFound is returned if the container does not
+// route through Tor.
+func (c *Container) TorStatus() (*TorStatus, error) {
+	_, err := os.Stat(path.Join(c.ConfigPath(), c.Name(), "tor-up.sh"))
+	if os.IsNotExist(err) {
+		return nil, errors.NotFoundf("tor client for %q", c.Name())
+	}
+	cookie, err := ioutil.ReadFile(path.Join(c.torDir(), "control_auth_cookie"))
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot read tor control cookie")
+	}
+	conn, err := net.DialTimeout("unix", path.Join(c.torDir(), "control"), 5*time.Second)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot connect to tor control socket")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	tc := &torControl{conn: conn, r: bufio.NewReader(conn)}
+	_, err = tc.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info, err := tc.command("GETINFO status/bootstrap-phase status/circuit-established circuit-status")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	status := &TorStatus{
+		Bootstrap:          strings.TrimPrefix(info["status/bootstrap-phase"], "NOTICE BOOTSTRAP "),
+		CircuitEstablished: info["status/circuit-established"] == "1",
+	}
+	for _, line := range strings.Split(info["circuit-status"], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == "BUILT" {
+			status.BuiltCircuits++
+		}
+	}
+	return status, nil
+}
+
+// torControl is a minimal client for the Tor control protocol.
+type torControl struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// command sends a command and returns the keyword values in its reply.
+func (tc *torControl) command(cmd string) (map[string]string, error) {
+	_, err := fmt.Fprintf(tc.conn, "%s\r\n", cmd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	values := make(map[string]string)
+	for {
+		line, err := tc.readLine()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(line) < 4 {
+			return nil, errors.Errorf("malformed tor control reply %q", line)
+		}
+		code, sep, text := line[:3], line[3], line[4:]
+		if n, err := strconv.Atoi(code); err != nil || n >= 400 {
+			return nil, errors.Errorf("tor control error: %s", line)
+		}
+		key, value := text, ""
+		if i := strings.Index(text, "="); i >= 0 {
+			key, value = text[:i], text[i+1:]
+		}
+		switch sep {
+		case ' ':
+			return values, nil
+		case '-':
+			values[key] = value
+		case '+':
+			// Data follows, terminated by a line holding a single '.'
+			var data []string
+			for {
+				dataLine, err := tc.readLine()
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				if dataLine == "." {
+					break
+				}
+				data = append(data, dataLine)
+			}
+			values[key] = strings.Join(data, "\n")
+		}
+	}
+}
+
+func (tc *torControl) readLine() (string, error) {
+	line, err := tc.r.ReadString('\n')
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,190 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+type TorSuite struct{}
+
+var _ = gc.Suite(&TorSuite{})
+
+// newTorContainer returns a container in Tor network mode, with a container
+// directory.
+func newTorContainer(c *gc.C, options ...Option) *Container {
+	options = append([]Option{Network(NetworkPolicy{Mode: NetworkTor})}, options...)
+	container := newTestContainer(c, options...)
+	err := os.Mkdir(filepath.Join(container.ConfigPath(), container.Name()), 0755)
+	c.Assert(err, gc.IsNil)
+	return container
+}
+
+func (*TorSuite) TestDerivedPorts(c *gc.C) {
+	container := newTestContainer(c)
+	transPort, dnsPort := container.torPorts()
+	c.Assert(transPort, gc.Equals, torPortBase+container.torPortSlot()*2)
+	c.Assert(dnsPort, gc.Equals, transPort+1)
+	c.Assert(transPort >= torPortBase && dnsPort < torPortBase+torPortSlots*2, gc.Equals, true)
+	c.Assert(newTestContainer(c).torPortSlot(), gc.Equals, container.torPortSlot())
+}
+
+func (*TorSuite) TestAssignPorts(c *gc.C) {
+	container := newTorContainer(c)
+	derived, _ := container.torPorts()
+
+	// Another container was assigned the derived ports.
+	other := filepath.Join(container.ConfigPath(), "other")
+	err := os.Mkdir(other, 0755)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(other, "tor.ports"),
+		[]byte(fmt.Sprintf("%d %d\n", derived, derived+1)), 0644)
+	c.Assert(err, gc.IsNil)
+
+	err = container.assignTorPorts()
+	c.Assert(err, gc.IsNil)
+	transPort, dnsPort := container.torPorts()
+	c.Assert(transPort, gc.Not(gc.Equals), derived)
+	c.Assert(dnsPort, gc.Equals, transPort+1)
+
+	// The assignment is kept.
+	err = ioutil.WriteFile(filepath.Join(other, "tor.ports"),
+		[]byte(fmt.Sprintf("%d %d\n", transPort, dnsPort)), 0644)
+	c.Assert(err, gc.IsNil)
+	err = container.assignTorPorts()
+	c.Assert(err, gc.IsNil)
+	transPort2, _ := container.torPorts()
+	c.Assert(transPort2, gc.Equals, transPort)
+}
+
+func (*TorSuite) TestAssignPortsInUse(c *gc.C) {
+	container := newTorContainer(c)
+	derived, _ := container.torPorts()
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", derived))
+	if err != nil {
+		c.Skip(fmt.Sprintf("cannot listen on port %d: %v", derived, err))
+	}
+	defer l.Close()
+
+	err = container.assignTorPorts()
+	c.Assert(err, gc.IsNil)
+	transPort, _ := container.torPorts()
+	c.Assert(transPort, gc.Not(gc.Equals), derived)
+}
+
+func (*TorSuite) TestEgressRules(c *gc.C) {
+	testCases := [][]Option{
+		nil,
+		{Firewall(FirewallRule{Destination: "192.0.2.0/24", Ports: []int{443}})},
+	}
+	for i, options := range testCases {
+		c.Log("test#", i)
+		container := newTestContainer(c, append(options, Network(NetworkPolicy{Mode: NetworkTor}))...)
+		ruleset, err := container.firewallRuleset()
+		c.Assert(err, gc.IsNil)
+		egress := chain(c, ruleset, "egress")
+		c.Assert(egress[:2], gc.DeepEquals, []string{
+			"ct state established,related accept",
+			"ether type arp accept",
+		})
+		// Only IPv4 is accepted, and so redirected. IPv6 is dropped.
+		for _, rule := range egress[2 : len(egress)-1] {
+			c.Assert(rule, gc.Matches, `(ether type ip|ip daddr) .* accept`)
+		}
+		c.Assert(egress[len(egress)-1], gc.Equals, "drop")
+		c.Assert(ruleset, gc.Not(gc.Matches), `(?s).*(ip6|ether type != ip).*`)
+	}
+}
+
+func (*TorSuite) TestSetupTor(c *gc.C) {
+	container := newTorContainer(c)
+	err := container.assignTorPorts()
+	c.Assert(err, gc.IsNil)
+	err = container.setupTor()
+	c.Assert(err, gc.IsNil)
+	transPort, dnsPort := container.torPorts()
+	up, err := ioutil.ReadFile(filepath.Join(container.ConfigPath(), container.Name(), "tor-up.sh"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(up), gc.Matches, `(?s).*TransPort \$BRIDGE_IP:`+strconv.Itoa(transPort)+
+		`\nDNSPort \$BRIDGE_IP:`+strconv.Itoa(dnsPort)+`\n.*`)
+	_, err = os.Stat(filepath.Join(container.ConfigPath(), container.Name(), "tor-down.sh"))
+	c.Assert(err, gc.IsNil)
+}
+
+func (*TorSuite) TestUpScript(c *gc.C) {
+	dir := c.MkDir()
+	binDir, log := filepath.Join(dir, "bin"), filepath.Join(dir, "commands.log")
+	err := os.Mkdir(binDir, 0700)
+	c.Assert(err, gc.IsNil)
+	fakeCommand(c, binDir, "ip", log, "3: lxcbr0    inet 10.0.3.1/24 brd 10.0.3.255 scope global lxcbr0\n")
+	fakeCommand(c, binDir, "tor", log, "")
+
+	torDir := filepath.Join(dir, "tor")
+	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf(torUpScript, torDir, "lxcbr0", 20000, 20001))
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":"+os.Getenv("PATH"))
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, gc.IsNil, gc.Commentf("%s", out))
+
+	torrc, err := ioutil.ReadFile(filepath.Join(torDir, "torrc"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(torrc), gc.Matches, `(?s).*\nTransPort 10.0.3.1:20000\nDNSPort 10.0.3.1:20001\n.*`)
+	c.Assert(string(torrc), gc.Matches, `(?s).*\nDataDirectory `+torDir+`\n.*`)
+	commands, err := ioutil.ReadFile(log)
+	c.Assert(err, gc.IsNil)
+	c.Assert(strings.Split(strings.TrimSpace(string(commands)), "\n"), gc.DeepEquals, []string{
+		"ip -4 -o addr show dev lxcbr0",
+		"tor -f " + torDir + "/torrc",
+	})
+
+	// The hook fails without a bridge address.
+	fakeCommand(c, binDir, "ip", log, "")
+	cmd = exec.Command("/bin/sh", "-c", fmt.Sprintf(torUpScript, torDir, "lxcbr0", 20000, 20001))
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":"+os.Getenv("PATH"))
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, gc.NotNil)
+	c.Assert(string(out), gc.Equals, "cannot find address of lxcbr0\n")
+}
+
+func (*TorSuite) TestDownScript(c *gc.C) {
+	torDir := c.MkDir()
+	tor := exec.Command("sleep", "60")
+	err := tor.Start()
+	c.Assert(err, gc.IsNil)
+	pidFile := filepath.Join(torDir, "tor.pid")
+	err = ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", tor.Process.Pid)), 0644)
+	c.Assert(err, gc.IsNil)
+
+	err = exec.Command("/bin/sh", "-c", fmt.Sprintf(torDownScript, torDir)).Run()
+	c.Assert(err, gc.IsNil)
+	c.Assert(tor.Wait(), gc.ErrorMatches, "signal: terminated")
+	_, err = os.Stat(pidFile)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	// Stopping without a running client succeeds.
+	err = exec.Command("/bin/sh", "-c", fmt.Sprintf(torDownScript, torDir)).Run()
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,354 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// VPNType is the kind of VPN a container connects through.
+type VPNType string
+
+const (
+	VPNWireGuard VPNType = "wireguard"
+	VPNOpenVPN   VPNType = "openvpn"
+)
+
+// VPNProfile describes a VPN which a container routes all of its traffic
+// through. Once the application is installed, a kill switch drops any traffic
+// which does not go to the VPN endpoints, so nothing leaks when the tunnel is
+// down.
+type VPNProfile struct {
+	Type VPNType
+
+	// Config is the path of a wg-quick(8) or OpenVPN client config on the
+	// host. Endpoint host names in it are resolved on the host at install
+	// time, and the copy in the container refers to the addresses, so that
+	// the container needs no DNS outside the tunnel.
+	Config string
+}
+
+// vpnEndpoint is a VPN server address from a config.
+type vpnEndpoint struct {
+	host  string
+	port  int
+	proto string
+}
+
+func (e vpnEndpoint) match() string {
+	family := "ip"
+	if ip := net.ParseIP(e.host); ip != nil && ip.To4() == nil {
+		family = "ip6"
+	}
+	return fmt.Sprintf("%s daddr %s %s dport %d", family, e.host, e.proto, e.port)
+}
+
+// VPN routes the container's traffic through a VPN. Firewall allow rules are
+// not supported with a VPN, since traffic to them would bypass the tunnel.
+func VPN(profile *VPNProfile) Option {
+	return func(c *Container) error {
+		if profile == nil {
+			return errors.New("vpn requires a profile")
+		}
+		switch profile.Type {
+		case VPNWireGuard, VPNOpenVPN:
+		default:
+			return errors.Errorf("unknown vpn type %q", profile.Type)
+		}
+		if profile.Config == "" {
+			return errors.Errorf("%s vpn requires a config file", profile.Type)
+		}
+		config, err := filepath.Abs(profile.Config)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		contents, err := ioutil.ReadFile(config)
+		if err != nil {
+			return errors.Annotate(err, "cannot read vpn config")
+		}
+		endpoints, err := vpnEndpoints(profile.Type, contents)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(endpoints) == 0 {
+			return errors.Errorf("no endpoints found in vpn config %q", profile.Config)
+		}
+		c.vpn = &VPNProfile{Type: profile.Type, Config: config}
+		return nil
+	}
+}
+
+// vpnEndpoints parses the endpoints in a VPN config.
+func vpnEndpoints(vpnType VPNType, config []byte) ([]vpnEndpoint, error) {
+	var endpoints []vpnEndpoint
+	_, err := rewriteVPNConfig(vpnType, config, func(e vpnEndpoint) ([]vpnEndpoint, error) {
+		endpoints = append(endpoints, e)
+		return nil, nil
+	})
+	return endpoints, errors.Trace(err)
+}
+
+// rewriteVPNConfig calls rewrite with each endpoint in a VPN config, and
+// returns the config with each endpoint replaced by those returned.
+func rewriteVPNConfig(vpnType VPNType, config []byte,
+	rewrite func(vpnEndpoint) ([]vpnEndpoint, error)) ([]byte, error) {
+
+	// OpenVPN defaults, which may be overridden by port and proto
+	// directives anywhere in the config.
+	port, proto := 1194, "udp"
+	if vpnType == VPNOpenVPN {
+		scanner := bufio.NewScanner(bytes.NewReader(config))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "port" {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil {
+					return nil, errors.Errorf("invalid openvpn port %q", fields[1])
+				}
+				port = n
+			} else if len(fields) == 2 && fields[0] == "proto" {
+				proto = openvpnProto(fields[1])
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(config))
+	for scanner.Scan() {
+		line := scanner.Text()
+		var endpoint *vpnEndpoint
+		switch vpnType {
+		case VPNWireGuard:
+			fields := strings.SplitN(line, "=", 2)
+			if len(fields) == 2 && strings.TrimSpace(fields[0]) == "Endpoint" {
+				value := strings.TrimSpace(fields[1])
+				host, portStr, err := net.SplitHostPort(value)
+				if err != nil {
+					return nil, errors.Annotatef(err, "invalid wireguard endpoint %q", value)
+				}
+				n, err := strconv.Atoi(portStr)
+				if err != nil {
+					return nil, errors.Errorf("invalid wireguard endpoint %q", value)
+				}
+				endpoint = &vpnEndpoint{host, n, "udp"}
+			}
+		case VPNOpenVPN:
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "remote" {
+				endpoint = &vpnEndpoint{fields[1], port, proto}
+				if len(fields) >= 3 {
+					n, err := strconv.Atoi(fields[2])
+					if err != nil {
+						return nil, errors.Errorf("invalid openvpn remote %q", line)
+					}
+					endpoint.port = n
+				}
+				if len(fields) >= 4 {
+					endpoint.proto = openvpnProto(fields[3])
+				}
+			}
+		}
+		if endpoint == nil {
+			fmt.Fprintln(&out, line)
+			continue
+		}
+		replacements, err := rewrite(*endpoint)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for i, e := range replacements {
+			switch vpnType {
+			case VPNWireGuard:
+				// WireGuard peers have a single endpoint.
+				if i == 0 {
+					fmt.Fprintf(&out, "Endpoint = %s\n", net.JoinHostPort(e.host, strconv.Itoa(e.port)))
+				}
+			case VPNOpenVPN:
+				fmt.Fprintf(&out, "remote %s %d %s\n", e.host, e.port, e.proto)
+			}
+		}
+	}
+	return out.Bytes(), errors.Trace(scanner.Err())
+}
+
+func openvpnProto(proto string) string {
+	if strings.HasPrefix(proto, "tcp") {
+		return "tcp"
+	}
+	return "udp"
+}
+
+// resolveVPNConfig reads the container's VPN config, and returns it with
+// endpoint host names replaced by their addresses, along with the resolved
+// endpoints.
+func (c *Container) resolveVPNConfig() ([]byte, []vpnEndpoint, error) {
+	config, err := ioutil.ReadFile(c.vpn.Config)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "cannot read vpn config")
+	}
+	var resolved []vpnEndpoint
+	config, err = rewriteVPNConfig(c.vpn.Type, config, func(e vpnEndpoint) ([]vpnEndpoint, error) {
+		var addrs []string
+		if net.ParseIP(e.host) != nil {
+			addrs = []string{e.host}
+		} else {
+			ips, err := net.LookupIP(e.host)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot resolve vpn endpoint %q", e.host)
+			}
+			// Prefer IPv4, which is more likely to be routed.
+			for _, ip := range ips {
+				if ip.To4() != nil {
+					addrs = append(addrs, ip.String())
+				}
+			}
+			for _, ip := range ips {
+				if ip.To4() == nil {
+					addrs = append(addrs, ip.String())
+				}
+			}
+		}
+		var endpoints []vpnEndpoint
+		for _, addr := range addrs {
+			endpoints = append(endpoints, vpnEndpoint{addr, e.port, e.proto})
+		}
+		if c.vpn.Type == VPNWireGuard {
+			endpoints = endpoints[:1]
+		}
+		resolved = append(resolved, endpoints...)
+		return endpoints, nil
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return config, resolved, nil
+}
+
+// vpnConfigPath returns where the VPN config is installed in the container.
+func (c *Container) vpnConfigPath() string {
+	if c.vpn.Type == VPNWireGuard {
+		return "/etc/wireguard/lxcify.conf"
+	}
+	return "/etc/openvpn/lxcify.conf"
+}
+
+func (c *Container) vpnPackage() string {
+	if c.vpn.Type == VPNWireGuard {
+		return "wireguard-tools"
+	}
+	return "openvpn"
+}
+
+// vpnMounts passes through the tun device, which OpenVPN cannot create in an
+// unprivileged container.
+func (c *Container) vpnMounts() []Mount {
+	if c.vpn.Type == VPNOpenVPN {
+		return []Mount{{Host: "/dev/net/tun", Container: "dev/net/tun"}}
+	}
+	return nil
+}
+
+// withVPNClient returns the app with the VPN client added to its packages,
+// so that it is installed, and checked against the distro, along with them.
+func (c *Container) withVPNClient(app *App) *App {
+	if c.vpn == nil {
+		return app
+	}
+	withClient := *app
+	withClient.Packages = append(append([]string(nil), app.Packages...), c.vpnPackage())
+	return &withClient
+}
+
+// installVPN installs the VPN config into the container. The client is
+// installed with the app's packages.
+func (c *Container) installVPN() error {
+	config, endpoints, err := c.resolveVPNConfig()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.vpnEndpoints = endpoints
+	err = c.writeContainerFile(config, c.vpnConfigPath())
+	return errors.Annotate(err, "cannot install vpn config")
+}
+
+// setupKillSwitch adds the VPN kill switch to the container's firewall,
+// installing the firewall if the container does not already have one. It
+// takes effect from the next start.
+func (c *Container) setupKillSwitch() error {
+	if len(c.vpnEndpoints) == 0 {
+		return errors.New("vpn endpoints have not been resolved")
+	}
+	if _, err := ioutil.ReadFile(c.firewallFile()); err == nil {
+		return errors.Trace(c.writeFirewall())
+	}
+	return errors.Trace(c.setupFirewall())
+}
+
+// vpnEgressRules allows traffic to the VPN endpoints, which carries
+// everything else once the tunnel is up.
+func (c *Container) vpnEgressRules() []string {
+	var rules []string
+	for _, e := range c.vpnEndpoints {
+		rules = append(rules, e.match()+" accept")
+	}
+	return rules
+}
+
+const wireguardUpScript = `if ! lxc-attach -n $CONTAINER -- wg-quick up lxcify; then
+    echo "cannot bring up vpn" >&2
+    lxc-stop -n $CONTAINER -t 10
+    exit 1
+fi
+`
+
+const openvpnUpScript = `lxc-attach -n $CONTAINER -- openvpn --daemon lxcify --config /etc/openvpn/lxcify.conf
+VPN_UP=false
+for i in $(seq 30); do
+    if lxc-attach -n $CONTAINER -- sh -c 'ip -o -4 addr show | grep -qE "^[0-9]+: (tun|tap)"'; then
+        VPN_UP=true
+        break
+    fi
+    sleep 1
+done
+if [ "$VPN_UP" != "true" ]; then
+    echo "timeout waiting for vpn" >&2
+    lxc-stop -n $CONTAINER -t 10
+    exit 1
+fi
+`
+
+// vpnUpScript returns launcher script commands which bring up the tunnel
+// after the container starts, and stop the container if they cannot.
+func (c *Container) vpnUpScript() string {
+	if c.vpn == nil {
+		return ""
+	}
+	if c.vpn.Type == VPNWireGuard {
+		return wireguardUpScript
+	}
+	return openvpnUpScript
+}
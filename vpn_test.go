@@ -0,0 +1,135 @@
+/* Copyright (c) 2014 Casey Marshall
+
+   This file is part of lxcify.
+
+   lxcify is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, version 3.
+
+   Foobar is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with lxcify. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lxcify
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type VPNSuite struct{}
+
+var _ = gc.Suite(&VPNSuite{})
+
+const wireguardConfig = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.200.0.2/32
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = 192.0.2.1:51820
+AllowedIPs = 0.0.0.0/0
+`
+
+func writeVPNConfig(c *gc.C, contents string) string {
+	config := filepath.Join(c.MkDir(), "vpn.conf")
+	err := ioutil.WriteFile(config, []byte(contents), 0600)
+	c.Assert(err, gc.IsNil)
+	return config
+}
+
+func (*VPNSuite) TestNilProfile(c *gc.C) {
+	_, err := NewContainer("test", ConfigPath(c.MkDir()), VPN(nil))
+	c.Assert(err, gc.ErrorMatches, "vpn requires a profile")
+}
+
+func (*VPNSuite) TestFirewallRules(c *gc.C) {
+	profile := &VPNProfile{Type: VPNWireGuard, Config: writeVPNConfig(c, wireguardConfig)}
+	_, err := NewContainer("test", ConfigPath(c.MkDir()), VPN(profile),
+		Firewall(FirewallRule{Destination: "10.0.0.0/8"}))
+	c.Assert(err, gc.ErrorMatches, "firewall rules are not supported with a vpn")
+	newTestContainer(c, VPN(profile), Firewall())
+}
+
+func (*VPNSuite) TestClientPackage(c *gc.C) {
+	app := &App{Packages: []string{"firefox"}}
+	c.Assert(newTestContainer(c).withVPNClient(app), gc.Equals, app)
+
+	profile := &VPNProfile{Type: VPNWireGuard, Config: writeVPNConfig(c, wireguardConfig)}
+	withClient := newTestContainer(c, VPN(profile)).withVPNClient(app)
+	c.Assert(withClient.Packages, gc.DeepEquals, []string{"firefox", "wireguard-tools"})
+	c.Assert(app.Packages, gc.DeepEquals, []string{"firefox"})
+	steps := installSteps(withClient)
+	c.Assert(steps, gc.HasLen, 1)
+	c.Assert(steps[0].script, gc.Matches, "(?s).*apt-get install -y --no-install-recommends firefox wireguard-tools\n")
+
+	profile = &VPNProfile{Type: VPNOpenVPN, Config: writeVPNConfig(c, "remote 192.0.2.1 1194\n")}
+	withClient = newTestContainer(c, VPN(profile)).withVPNClient(&App{})
+	c.Assert(withClient.Packages, gc.DeepEquals, []string{"openvpn"})
+	c.Assert(withClient.Check("fedora"), gc.ErrorMatches, `packages are not supported on distro "fedora".*`)
+}
+
+func (*VPNSuite) TestEndpoints(c *gc.C) {
+	endpoints, err := vpnEndpoints(VPNWireGuard, []byte(wireguardConfig))
+	c.Assert(err, gc.IsNil)
+	c.Assert(endpoints, gc.DeepEquals, []vpnEndpoint{{"192.0.2.1", 51820, "udp"}})
+
+	endpoints, err = vpnEndpoints(VPNOpenVPN, []byte(`client
+remote vpn.example.com
+remote 192.0.2.2 443 tcp-client
+remote 2001:db8::1 1195
+port 1196
+`))
+	c.Assert(err, gc.IsNil)
+	c.Assert(endpoints, gc.DeepEquals, []vpnEndpoint{
+		{"vpn.example.com", 1196, "udp"},
+		{"192.0.2.2", 443, "tcp"},
+		{"2001:db8::1", 1195, "udp"},
+	})
+
+	_, err = vpnEndpoints(VPNWireGuard, []byte("Endpoint = 192.0.2.1\n"))
+	c.Assert(err, gc.ErrorMatches, `invalid wireguard endpoint "192.0.2.1": .*`)
+}
+
+func (*VPNSuite) TestResolveConfig(c *gc.C) {
+	container := newTestContainer(c, VPN(&VPNProfile{
+		Type:   VPNOpenVPN,
+		Config: writeVPNConfig(c, "client\nproto tcp\nremote 192.0.2.1 443\nremote 2001:db8::1\n"),
+	}))
+	config, endpoints, err := container.resolveVPNConfig()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(config), gc.Equals,
+		"client\nproto tcp\nremote 192.0.2.1 443 tcp\nremote 2001:db8::1 1194 tcp\n")
+	c.Assert(endpoints, gc.DeepEquals, []vpnEndpoint{
+		{"192.0.2.1", 443, "tcp"},
+		{"2001:db8::1", 1194, "tcp"},
+	})
+}
+
+func (*VPNSuite) TestKillSwitchRuleset(c *gc.C) {
+	container := newTestContainer(c, VPN(&VPNProfile{
+		Type:   VPNWireGuard,
+		Config: writeVPNConfig(c, wireguardConfig),
+	}))
+	c.Assert(container.firewallEnabled(), gc.Equals, false)
+
+	container.vpnEndpoints = []vpnEndpoint{{"192.0.2.1", 51820, "udp"}, {"2001:db8::1", 51820, "udp"}}
+	c.Assert(container.firewallEnabled(), gc.Equals, true)
+	ruleset, err := container.firewallRuleset()
+	c.Assert(err, gc.IsNil)
+	c.Assert(chain(c, ruleset, "egress"), gc.DeepEquals, []string{
+		"ct state established,related accept",
+		"ether type arp accept",
+		"ether type ip udp dport { 67, 68 } accept",
+		"ip daddr 192.0.2.1 udp dport 51820 accept",
+		"ip6 daddr 2001:db8::1 udp dport 51820 accept",
+		"drop",
+	})
+}